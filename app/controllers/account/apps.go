@@ -0,0 +1,48 @@
+package account
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/models"
+)
+
+// ListApps handles the request to list the OAuth applications the authenticated user has authorized.
+func ListApps(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	var authorizations []models.OAuthAuthorization
+	if err := lib.DB.Where(models.OAuthAuthorization{
+		UserID: session.Connection.UserID,
+	}).Preload("Client").Order("created_at DESC").Find(&authorizations).Error; err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.Response{
+		Success: true,
+		Data:    authorizations,
+	})
+}
+
+// RevokeApp handles the request to revoke an application's access, invalidating every token issued to it for the user.
+func RevokeApp(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	tx := lib.DB.Begin()
+
+	revoked, err := lib.RevokeOAuthAuthorization(tx, session.Connection.UserID, c.Params("client"))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if revoked == 0 {
+		tx.Rollback()
+		return lib.ErrNotFound
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+}
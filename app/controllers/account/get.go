@@ -11,6 +11,14 @@ func GetAccount(c *fiber.Ctx) error {
 	// Extract the user session from the context, which contains the user's account details.
 	session := c.Locals("session").(models.Session)
 
+	// Include the user's permissions so clients know which moderation tools to show.
+	permissions, err := lib.GetPermissions(lib.DB, session.Connection.UserID)
+	if err != nil {
+		return err
+	}
+
+	session.Connection.User.Permissions = permissions
+
 	// Respond with the user's session data encapsulated in a standard response structure.
 	return c.Status(fiber.StatusOK).JSON(lib.Response{
 		Success: true,
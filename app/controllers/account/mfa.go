@@ -0,0 +1,231 @@
+package account
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/models"
+)
+
+// MFACodeDTO holds an MFA code submitted to confirm a change to MFA settings.
+type MFACodeDTO struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+// GetMFA handles the request to retrieve the MFA status of the authenticated user.
+func GetMFA(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	return c.Status(fiber.StatusOK).JSON(lib.Response{
+		Success: true,
+		Data: fiber.Map{
+			"enabled": session.Connection.User.MFA != "",
+		},
+	})
+}
+
+// EnrollMFA handles the request to start MFA enrollment, returning the secret for an authenticator app.
+func EnrollMFA(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+	user := session.Connection.User
+
+	if user.MFA != "" {
+		return lib.NewError(fiber.StatusConflict, "Multi-factor authentication is already enabled.", nil, "MFA_ENABLED")
+	}
+
+	return startEnrollment(c, user)
+}
+
+// ConfirmMFA handles the request to confirm a pending secret with a code, enabling MFA or replacing the current secret.
+func ConfirmMFA(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+	user := session.Connection.User
+
+	var dto MFACodeDTO
+	if err := lib.ParseAndValidate(c, &dto); err != nil {
+		return err
+	}
+
+	if user.MFAPending == "" {
+		return lib.NewError(fiber.StatusBadRequest, "There is no pending multi-factor authentication setup to confirm.", nil, "MFA_NOT_PENDING")
+	}
+
+	// Codes from a pending secret have never been accepted, so only the secret itself is checked.
+	step, ok := lib.ValidateMFACode(user.MFAPending, dto.Code, 0)
+	if !ok {
+		return lib.ErrInvalidMFACode
+	}
+
+	tx := lib.DB.Begin()
+
+	if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]any{
+		"mfa":           user.MFAPending,
+		"mfa_pending":   "",
+		"mfa_last_step": step,
+	}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Replacing the secret of an already enabled account keeps the existing recovery codes.
+	if user.MFA != "" {
+		if err := tx.Commit().Error; err != nil {
+			return err
+		}
+
+		return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+	}
+
+	// Issue recovery codes when MFA is first enabled, these are only ever shown once.
+	codes, err := lib.GenerateRecoveryCodes(tx, user.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.Response{
+		Success: true,
+		Data: fiber.Map{
+			"recovery_codes": codes,
+		},
+	})
+}
+
+// RegenerateMFA handles the request to replace the MFA secret, which must be confirmed before it takes effect.
+func RegenerateMFA(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+	user := session.Connection.User
+
+	var dto MFACodeDTO
+	if err := lib.ParseAndValidate(c, &dto); err != nil {
+		return err
+	}
+
+	if user.MFA == "" {
+		return lib.NewError(fiber.StatusBadRequest, "Multi-factor authentication is not enabled.", nil, "MFA_DISABLED")
+	}
+
+	if err := lib.UseMFACode(lib.DB, user, user.MFA, dto.Code); err != nil {
+		return err
+	}
+
+	return startEnrollment(c, user)
+}
+
+// DisableMFA handles the request to turn off MFA after checking a current code.
+func DisableMFA(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+	user := session.Connection.User
+
+	var dto MFACodeDTO
+	if err := lib.ParseAndValidate(c, &dto); err != nil {
+		return err
+	}
+
+	if user.MFA == "" {
+		return lib.NewError(fiber.StatusBadRequest, "Multi-factor authentication is not enabled.", nil, "MFA_DISABLED")
+	}
+
+	tx := lib.DB.Begin()
+
+	if err := lib.UseMFACode(tx, user, user.MFA, dto.Code); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]any{
+		"mfa":           "",
+		"mfa_pending":   "",
+		"mfa_last_step": 0,
+	}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Where(models.RecoveryCode{UserID: user.ID}).Delete(&models.RecoveryCode{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+}
+
+// ListRecoveryCodes handles the request to list the authenticated user's recovery codes and when they were used.
+func ListRecoveryCodes(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	var codes []models.RecoveryCode
+	if err := lib.DB.Where(models.RecoveryCode{
+		UserID: session.Connection.UserID,
+	}).Order("created_at asc").Find(&codes).Error; err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.Response{
+		Success: true,
+		Data:    codes,
+	})
+}
+
+// RegenerateRecoveryCodes handles the request to replace all recovery codes with a new set.
+func RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+	user := session.Connection.User
+
+	var dto MFACodeDTO
+	if err := lib.ParseAndValidate(c, &dto); err != nil {
+		return err
+	}
+
+	if user.MFA == "" {
+		return lib.NewError(fiber.StatusBadRequest, "Multi-factor authentication is not enabled.", nil, "MFA_DISABLED")
+	}
+
+	tx := lib.DB.Begin()
+
+	if err := lib.UseMFACode(tx, user, user.MFA, dto.Code); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	codes, err := lib.GenerateRecoveryCodes(tx, user.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.Response{
+		Success: true,
+		Data: fiber.Map{
+			"recovery_codes": codes,
+		},
+	})
+}
+
+// startEnrollment stores a new pending secret on the user and responds with its setup details.
+func startEnrollment(c *fiber.Ctx, user *models.User) error {
+	enrollment, err := lib.NewMFAEnrollment(user.Username)
+	if err != nil {
+		return err
+	}
+
+	if err := lib.DB.Model(&models.User{}).Where("id = ?", user.ID).Update("mfa_pending", enrollment.Secret).Error; err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.Response{
+		Success: true,
+		Data:    enrollment,
+	})
+}
@@ -0,0 +1,61 @@
+package account
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/models"
+)
+
+// ListMutes handles the request to list the users the authenticated user has muted, most recent first.
+func ListMutes(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	params, err := lib.ParseCursorParams(c)
+	if err != nil {
+		return err
+	}
+
+	var mutes []models.Mute
+	if err := params.Apply(lib.DB.Where(&models.Mute{
+		UserID: session.Connection.UserID,
+	}).Preload("Muted"), "mutes").Find(&mutes).Error; err != nil {
+		return err
+	}
+
+	mutes, pagination := lib.CursorPage(params, mutes, func(mute models.Mute) lib.Cursor {
+		return lib.Cursor{CreatedAt: mute.CreatedAt, ID: mute.ID}
+	})
+
+	return c.Status(fiber.StatusOK).JSON(lib.Response{
+		Success:    true,
+		Data:       mutes,
+		Pagination: pagination,
+	})
+}
+
+// ListBlocks handles the request to list the users the authenticated user has blocked, most recent first.
+func ListBlocks(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	params, err := lib.ParseCursorParams(c)
+	if err != nil {
+		return err
+	}
+
+	var blocks []models.Block
+	if err := params.Apply(lib.DB.Where(&models.Block{
+		UserID: session.Connection.UserID,
+	}).Preload("Blocked"), "blocks").Find(&blocks).Error; err != nil {
+		return err
+	}
+
+	blocks, pagination := lib.CursorPage(params, blocks, func(block models.Block) lib.Cursor {
+		return lib.Cursor{CreatedAt: block.CreatedAt, ID: block.ID}
+	})
+
+	return c.Status(fiber.StatusOK).JSON(lib.Response{
+		Success:    true,
+		Data:       blocks,
+		Pagination: pagination,
+	})
+}
@@ -3,8 +3,10 @@ package account
 import (
 	"github.com/alexedwards/argon2id"
 	"github.com/gofiber/fiber/v2"
+	"github.com/twibber/api/app/controllers/auth/oauth"
 	"github.com/twibber/api/lib"
 	"github.com/twibber/api/models"
+	"gorm.io/gorm/clause"
 	"strings"
 )
 
@@ -16,12 +18,9 @@ func ListSessions(c *fiber.Ctx) error {
 	// Prepare a slice to hold the user's sessions.
 	var sessions []models.Session
 
-	// Query the database for sessions associated with the user.
-	if err := lib.DB.Where(models.Session{
-		Connection: &models.Connection{
-			UserID: user.Connection.User.ID,
-		},
-	}).Find(&sessions).Error; err != nil {
+	// Query the database for sessions associated with any of the user's connections.
+	if err := lib.DB.Where("connection_id IN (?)", lib.UserConnections(lib.DB, user.Connection.UserID)).
+		Find(&sessions).Error; err != nil {
 		// If there is a query error, return it.
 		return err
 	}
@@ -49,10 +48,8 @@ func GetSession(c *fiber.Ctx) error {
 		BaseModel: models.BaseModel{
 			ID: sessionID,
 		},
-		Connection: &models.Connection{
-			UserID: user.Connection.UserID,
-		},
-	}).First(&session).Error; err != nil {
+	}).Where("connection_id IN (?)", lib.UserConnections(lib.DB, user.Connection.UserID)).
+		First(&session).Error; err != nil {
 		// If there is a query error, return it.
 		return err
 	}
@@ -84,10 +81,8 @@ func DeleteSession(c *fiber.Ctx) error {
 		BaseModel: models.BaseModel{
 			ID: sessionID,
 		},
-		Connection: &models.Connection{
-			UserID: userSession.Connection.UserID,
-		},
-	}).First(&session).Error; err != nil {
+	}).Where("connection_id IN (?)", lib.UserConnections(lib.DB, userSession.Connection.UserID)).
+		First(&session).Error; err != nil {
 		// If there is a query error, return it.
 		return err
 	}
@@ -102,6 +97,41 @@ func DeleteSession(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
 }
 
+// RevokeSessionsResponse reports how many sessions were revoked, and how many API tokens when they are revoked too.
+type RevokeSessionsResponse struct {
+	Revoked       int64 `json:"revoked"`
+	RevokedTokens int64 `json:"revoked_tokens,omitempty"`
+}
+
+// DeleteSessions handles the request to log out of every session of the authenticated user.
+// The current session is kept when the keep_current query parameter is true.
+func DeleteSessions(c *fiber.Ctx) error {
+	// Extract the user session from the context.
+	userSession := c.Locals("session").(models.Session)
+
+	keepCurrent := c.QueryBool("keep_current")
+
+	var keepSessionID string
+	if keepCurrent {
+		keepSessionID = userSession.ID
+	}
+
+	revoked, err := lib.RevokeSessions(lib.DB, userSession.Connection.UserID, keepSessionID)
+	if err != nil {
+		return err
+	}
+
+	// The current session has been revoked too, so the cookie is cleared.
+	if !keepCurrent {
+		lib.ClearAuth(c)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.Response{
+		Success: true,
+		Data:    RevokeSessionsResponse{Revoked: revoked},
+	})
+}
+
 // ListConnections handles the request to list all connections for the authenticated user.
 func ListConnections(c *fiber.Ctx) error {
 	// Retrieve the user session from the context.
@@ -154,6 +184,85 @@ func GetConnection(c *fiber.Ctx) error {
 	})
 }
 
+// LinkConnection handles the request to start linking an external provider account to the authenticated user.
+func LinkConnection(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	// Look up the provider the user wants to link.
+	provider, ok := lib.GetOAuthProvider(models.ConnectionType(c.Params("provider")))
+	if !ok {
+		return lib.NewError(fiber.StatusBadRequest, "Invalid provider", nil)
+	}
+
+	// Return the URL the client should send the user to, the callback completes the link.
+	return c.Status(fiber.StatusOK).JSON(lib.Response{
+		Success: true,
+		Data: fiber.Map{
+			"url": oauth.Begin(c, provider, oauth.IntentLink, session.Connection.UserID),
+		},
+	})
+}
+
+// DeleteConnection handles the request to unlink a connection and revoke its sessions.
+func DeleteConnection(c *fiber.Ctx) error {
+	// Retrieve the user session from the context.
+	session := c.Locals("session").(models.Session)
+
+	connID := c.Params("connection")
+
+	tx := lib.DB.Begin()
+
+	// Lock every connection of the user, so concurrent removals cannot each see another connection left.
+	var connIDs []string
+	if err := tx.Model(&models.Connection{}).Clauses(clause.Locking{Strength: "UPDATE"}).Where(models.Connection{
+		UserID: session.Connection.UserID,
+	}).Pluck("id", &connIDs).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Prepare a variable to hold the connection.
+	var connection models.Connection
+	if err := tx.Where(models.Connection{
+		BaseModel: models.BaseModel{
+			ID: connID,
+		},
+		UserID: session.Connection.UserID,
+	}).First(&connection).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Refuse to remove the last way of logging in to the account.
+	if len(connIDs) <= 1 {
+		tx.Rollback()
+		return lib.NewError(fiber.StatusBadRequest, "You cannot remove your only connection.", nil, "LAST_CONNECTION")
+	}
+
+	// Revoke all sessions created through the connection before removing it.
+	if err := tx.Where(models.Session{ConnectionID: connection.ID}).Delete(&models.Session{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Delete(&connection).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	// The current session was revoked along with the connection.
+	if connection.ID == session.ConnectionID {
+		lib.ClearAuth(c)
+	}
+
+	// Return a success response.
+	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+}
+
 type UpdateConnectionPasswordDTO struct {
 	OldPassword string `json:"old_password"`
 	NewPassword string `json:"new_password"`
@@ -187,6 +296,10 @@ func UpdateConnectionPassword(c *fiber.Ctx) error {
 		return err
 	}
 
+	if connection.Password == "" {
+		return lib.ErrPasswordResetRequired
+	}
+
 	// check if the old password is correct
 	match, err := argon2id.ComparePasswordAndHash(dto.OldPassword, connection.Password)
 	if err != nil {
@@ -206,11 +319,38 @@ func UpdateConnectionPassword(c *fiber.Ctx) error {
 
 	// Hash the new password
 	passwordHash, err := argon2id.CreateHash(dto.NewPassword, &lib.ArgonConfig)
+	if err != nil {
+		return err
+	}
+
+	tx := lib.DB.Begin()
 
-	if err := lib.DB.Model(&connection).Update("password", passwordHash).Error; err != nil {
+	if err := tx.Model(&connection).Update("password", passwordHash).Error; err != nil {
+		tx.Rollback()
 		return err
 	}
 
-	// Return a success response.
-	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+	// Every other session is logged out, as the old password may have been used to create it.
+	revoked, err := lib.RevokeSessions(tx, user.Connection.UserID, user.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// API tokens and OAuth grants could have been created with the old password just the same.
+	revokedTokens, err := lib.RevokeAPIAccess(tx, user.Connection.UserID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	// Return a success response with the number of sessions and tokens revoked.
+	return c.Status(fiber.StatusOK).JSON(lib.Response{
+		Success: true,
+		Data:    RevokeSessionsResponse{Revoked: revoked, RevokedTokens: revokedTokens},
+	})
 }
@@ -0,0 +1,113 @@
+package account
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/models"
+	"time"
+)
+
+// maxAPITokens is the number of API tokens a user may have at once.
+const maxAPITokens = 25
+
+type CreateTokenDTO struct {
+	Name      string     `json:"name"       validate:"required,max=64"`
+	Scopes    []string   `json:"scopes"     validate:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at" validate:"omitempty"`
+}
+
+// CreateTokenResponse holds a newly created API token, which is only ever returned here.
+type CreateTokenResponse struct {
+	Token    string           `json:"token"`
+	APIToken *models.APIToken `json:"api_token"`
+}
+
+// ListTokens handles the request to list the personal API tokens of the authenticated user.
+// Tokens issued to OAuth clients are managed through authorized apps instead.
+func ListTokens(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	var tokens []models.APIToken
+	if err := lib.DB.Where(models.APIToken{
+		UserID: session.Connection.UserID,
+	}).Where("client_id IS NULL").Order("created_at DESC").Find(&tokens).Error; err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.Response{
+		Success: true,
+		Data:    tokens,
+	})
+}
+
+// CreateToken handles the request to create an API token with the given scopes.
+func CreateToken(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	var dto CreateTokenDTO
+	if err := lib.ParseAndValidate(c, &dto); err != nil {
+		return err
+	}
+
+	for _, scope := range dto.Scopes {
+		if !lib.ValidScope(scope) {
+			return lib.NewError(fiber.StatusBadRequest, "Unknown scope requested.", &lib.ErrorDetails{
+				Fields: []lib.ErrorField{
+					{Name: "scopes", Errors: []string{"The scope " + scope + " does not exist."}},
+				},
+			})
+		}
+	}
+
+	if dto.ExpiresAt != nil && dto.ExpiresAt.Before(time.Now()) {
+		return lib.NewError(fiber.StatusBadRequest, "The expiry must be in the future.", &lib.ErrorDetails{
+			Fields: []lib.ErrorField{
+				{Name: "expires_at", Errors: []string{"The expiry must be in the future."}},
+			},
+		})
+	}
+
+	var count int64
+	if err := lib.DB.Model(&models.APIToken{}).Where(models.APIToken{
+		UserID: session.Connection.UserID,
+	}).Where("client_id IS NULL").Count(&count).Error; err != nil {
+		return err
+	}
+	if count >= maxAPITokens {
+		return lib.NewError(fiber.StatusBadRequest, "You have reached the maximum number of API tokens.", nil, "TOO_MANY_TOKENS")
+	}
+
+	token, apiToken, err := lib.CreateAPIToken(lib.DB, session.Connection.UserID, dto.Name, dto.Scopes, dto.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(lib.Response{
+		Success: true,
+		Data: CreateTokenResponse{
+			Token:    token,
+			APIToken: apiToken,
+		},
+	})
+}
+
+// DeleteToken handles the request to revoke one of the authenticated user's API tokens.
+func DeleteToken(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	result := lib.DB.Where(models.APIToken{
+		BaseModel: models.BaseModel{
+			ID: c.Params("token"),
+		},
+		UserID: session.Connection.UserID,
+	}).Where("client_id IS NULL").Delete(&models.APIToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return lib.ErrNotFound
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+}
@@ -1,9 +1,17 @@
 package account
 
 import (
+	"errors"
+	"github.com/alexedwards/argon2id"
 	"github.com/gofiber/fiber/v2"
+	log "github.com/sirupsen/logrus"
+	cfg "github.com/twibber/api/config"
 	"github.com/twibber/api/lib"
+	"github.com/twibber/api/mailer"
 	"github.com/twibber/api/models"
+	"gorm.io/gorm"
+	"net/url"
+	"time"
 )
 
 type UpdateProfileDTO struct {
@@ -49,3 +57,86 @@ func UpdateProfile(c *fiber.Ctx) error {
 
 	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
 }
+
+// emailChangeDuration is how long the new address has to confirm an email change.
+const emailChangeDuration = time.Hour
+
+type ChangeEmailDTO struct {
+	Email    string `json:"email"    validate:"required,email,max=512"`
+	Password string `json:"password" validate:""`
+}
+
+// ChangeEmail sends a confirmation link to the new address, the change is applied once it has been followed.
+func ChangeEmail(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+	user := session.Connection.User
+
+	var dto ChangeEmailDTO
+	if err := lib.ParseAndValidate(c, &dto); err != nil {
+		return err
+	}
+
+	if dto.Email == user.Email {
+		return lib.NewError(fiber.StatusBadRequest, "This is already your email address.", nil)
+	}
+
+	// Accounts with a password must confirm it before changing the address it is tied to.
+	var connection models.Connection
+	err := lib.DB.Where(models.Connection{
+		BaseModel: models.BaseModel{
+			ID: models.ProviderEmailType.WithID(user.Email),
+		},
+	}).First(&connection).Error
+	if err == nil {
+		if connection.Password == "" {
+			return lib.ErrPasswordResetRequired
+		}
+
+		match, err := argon2id.ComparePasswordAndHash(dto.Password, connection.Password)
+		if err != nil {
+			return err
+		}
+
+		if !match {
+			return lib.NewError(fiber.StatusBadRequest, "Incorrect password", &lib.ErrorDetails{
+				Fields: []lib.ErrorField{
+					{
+						Name:   "password",
+						Errors: []string{"The password is incorrect"},
+					},
+				},
+			})
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	var count int64
+	if err := lib.DB.Model(models.User{}).Where(models.User{Email: dto.Email}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return lib.ErrEmailExists
+	}
+
+	token, err := lib.IssueToken(lib.DB, models.TokenEmailChange, session.ConnectionID, dto.Email, emailChangeDuration)
+	if err != nil {
+		return err
+	}
+
+	// concurrently send the confirmation link to the new address
+	go func() {
+		err := mailer.EmailChangeDTO{
+			Defaults: mailer.Defaults{
+				Email: dto.Email,
+				Name:  user.Username,
+			},
+			Link: cfg.Config.PublicURL + "/auth/email/change?token=" + url.QueryEscape(lib.SignValue(token)),
+		}.Send()
+		if err != nil {
+			log.WithError(err).Error("email change confirmation could not be sent")
+		}
+	}()
+
+	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+}
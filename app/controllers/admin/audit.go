@@ -0,0 +1,37 @@
+package admin
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/models"
+)
+
+// ListAuditLogs handles the request to list audit log entries, most recent first, by page number.
+// Entries can be filtered by the staff member with ?actor= and by the user acted on with ?subject=.
+func ListAuditLogs(c *fiber.Ctx) error {
+	params, err := lib.ParsePageParams(c)
+	if err != nil {
+		return err
+	}
+
+	query := lib.DB.Model(&models.AuditLog{}).Preload("Actor").Preload("Subject")
+
+	if actor := c.Query("actor"); actor != "" {
+		query = query.Where(models.AuditLog{ActorID: actor})
+	}
+	if subject := c.Query("subject"); subject != "" {
+		query = query.Where(models.AuditLog{SubjectID: &subject})
+	}
+
+	var logs []models.AuditLog
+	pagination, err := params.Paginate(query.Order("created_at DESC").Order("id"), &logs)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.Response{
+		Success:    true,
+		Data:       logs,
+		Pagination: pagination,
+	})
+}
@@ -0,0 +1,47 @@
+package admin
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/models"
+	"time"
+)
+
+// ImpersonateResponse holds the token of an impersonation session, which is only ever returned here.
+type ImpersonateResponse struct {
+	Token     string    `json:"token"`
+	SessionID string    `json:"session_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Impersonate handles the request from a staff member to act as a user.
+// The token is returned rather than set as a cookie, so the staff member's own session is kept.
+func Impersonate(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	user, err := getUser(c)
+	if err != nil {
+		return err
+	}
+
+	tx := lib.DB.Begin()
+
+	token, impersonation, err := lib.Impersonate(tx, c, session.Connection.UserID, user)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(lib.Response{
+		Success: true,
+		Data: ImpersonateResponse{
+			Token:     token,
+			SessionID: impersonation.ID,
+			ExpiresAt: impersonation.ExpiresAt,
+		},
+	})
+}
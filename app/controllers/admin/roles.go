@@ -0,0 +1,115 @@
+package admin
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// getUser returns the user named in the route, refusing to let staff act on their own account.
+func getUser(c *fiber.Ctx) (*models.User, error) {
+	session := c.Locals("session").(models.Session)
+
+	var user models.User
+	if err := lib.DB.Where(models.User{
+		Username: c.Params("user"),
+	}).First(&user).Error; err != nil {
+		return nil, err
+	}
+
+	if user.ID == session.Connection.UserID {
+		return nil, lib.NewError(fiber.StatusBadRequest, "You cannot perform this action on your own account.", nil)
+	}
+
+	return &user, nil
+}
+
+// ListRoles handles the request to list every role and the permissions it grants.
+func ListRoles(c *fiber.Ctx) error {
+	var roles []models.Role
+	if err := lib.DB.Order("id").Find(&roles).Error; err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.Response{
+		Success: true,
+		Data:    roles,
+	})
+}
+
+// ListUserRoles handles the request to list the roles granted to a user.
+func ListUserRoles(c *fiber.Ctx) error {
+	var user models.User
+	if err := lib.DB.Where(models.User{
+		Username: c.Params("user"),
+	}).First(&user).Error; err != nil {
+		return err
+	}
+
+	var roles []models.UserRole
+	if err := lib.DB.Where(models.UserRole{
+		UserID: user.ID,
+	}).Preload("Role").Preload("GrantedBy").Order("created_at").Find(&roles).Error; err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.Response{
+		Success: true,
+		Data:    roles,
+	})
+}
+
+// GrantRole handles the request to grant a role to a user, granting a role they already hold does nothing.
+func GrantRole(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	user, err := getUser(c)
+	if err != nil {
+		return err
+	}
+
+	var role models.Role
+	if err := lib.DB.Where(models.Role{
+		BaseModel: models.BaseModel{ID: c.Params("role")},
+	}).First(&role).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return lib.NewError(fiber.StatusNotFound, "The role does not exist.", nil, "ROLE_NOT_FOUND")
+		}
+		return err
+	}
+
+	if err := lib.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.UserRole{
+		UserID:      user.ID,
+		RoleID:      role.ID,
+		GrantedByID: &session.Connection.UserID,
+	}).Error; err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+}
+
+// RevokeRole handles the request to remove a role from a user.
+func RevokeRole(c *fiber.Ctx) error {
+	user, err := getUser(c)
+	if err != nil {
+		return err
+	}
+
+	result := lib.DB.Where(models.UserRole{
+		UserID: user.ID,
+		RoleID: c.Params("role"),
+	}).Delete(&models.UserRole{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return lib.ErrNotFound
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+}
@@ -0,0 +1,76 @@
+package admin
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/models"
+	"time"
+)
+
+type SuspendDTO struct {
+	Reason string     `json:"reason" validate:"required,max=1024,notblank"`
+	Until  *time.Time `json:"until"  validate:"omitempty"` // Suspends indefinitely if empty
+}
+
+// SuspendUser handles the request to suspend a user, who is then refused on every authenticated endpoint.
+func SuspendUser(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	var dto SuspendDTO
+	if err := lib.ParseAndValidate(c, &dto); err != nil {
+		return err
+	}
+
+	if dto.Until != nil && dto.Until.Before(time.Now()) {
+		return lib.NewError(fiber.StatusBadRequest, "The end of the suspension must be in the future.", &lib.ErrorDetails{
+			Fields: []lib.ErrorField{
+				{Name: "until", Errors: []string{"The end of the suspension must be in the future."}},
+			},
+		})
+	}
+
+	user, err := getUser(c)
+	if err != nil {
+		return err
+	}
+
+	tx := lib.DB.Begin()
+
+	if err := lib.Suspend(tx, c, session.Connection.UserID, user, dto.Reason, dto.Until); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+}
+
+// UnsuspendUser handles the request to lift a user's suspension.
+func UnsuspendUser(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	user, err := getUser(c)
+	if err != nil {
+		return err
+	}
+
+	if !user.Suspended {
+		return lib.NewError(fiber.StatusBadRequest, "The user is not suspended.", nil, "NOT_SUSPENDED")
+	}
+
+	tx := lib.DB.Begin()
+
+	if err := lib.Unsuspend(tx, c, session.Connection.UserID, user); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+}
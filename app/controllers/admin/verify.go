@@ -0,0 +1,33 @@
+package admin
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/models"
+)
+
+// setVerified marks the user named in the route as a verified person or not.
+func setVerified(c *fiber.Ctx, verified bool) error {
+	result := lib.DB.Model(&models.User{}).Where(models.User{
+		Username: c.Params("user"),
+	}).Update("verified_person", verified)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return lib.ErrNotFound
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+}
+
+// VerifyUser handles the request to mark a user as a verified person.
+func VerifyUser(c *fiber.Ctx) error {
+	return setVerified(c, true)
+}
+
+// UnverifyUser handles the request to remove a user's verified status.
+func UnverifyUser(c *fiber.Ctx) error {
+	return setVerified(c, false)
+}
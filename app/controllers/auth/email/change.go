@@ -0,0 +1,202 @@
+package email
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	log "github.com/sirupsen/logrus"
+	cfg "github.com/twibber/api/config"
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/mailer"
+	"github.com/twibber/api/models"
+	"gorm.io/gorm"
+	"net/url"
+	"time"
+)
+
+// revertDuration is how long the previous address can undo an email change.
+const revertDuration = 72 * time.Hour
+
+type EmailTokenDTO struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// ConfirmEmailChange applies an email change once the new address has been confirmed through its link,
+// and notifies the previous address with a link to revert the change.
+func ConfirmEmailChange(c *fiber.Ctx) error {
+	var dto EmailTokenDTO
+	if err := lib.ParseAndValidate(c, &dto); err != nil {
+		return err
+	}
+
+	token, ok := lib.VerifySignedValue(dto.Token)
+	if !ok {
+		return lib.ErrInvalidToken
+	}
+
+	tx := lib.DB.Begin()
+
+	oneTimeToken, err := lib.ConsumeToken(tx, models.TokenEmailChange, token)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	user := oneTimeToken.Connection.User
+	oldEmail := user.Email
+
+	if err := changeEmail(tx, user, oneTimeToken.Data); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// The revert link is tied to the connection the change was requested from, which may have just been renamed.
+	connectionID := oneTimeToken.ConnectionID
+	if connectionID == models.ProviderEmailType.WithID(oldEmail) {
+		connectionID = models.ProviderEmailType.WithID(user.Email)
+	}
+
+	revertToken, err := lib.IssueToken(tx, models.TokenEmailRevert, connectionID, oldEmail, revertDuration)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	// concurrently notify the previous address of the change
+	go func() {
+		err := mailer.EmailChangedDTO{
+			Defaults: mailer.Defaults{
+				Email: oldEmail,
+				Name:  user.Username,
+			},
+			NewEmail: user.Email,
+			Link:     cfg.Config.PublicURL + "/auth/email/revert?token=" + url.QueryEscape(lib.SignValue(revertToken)),
+		}.Send()
+		if err != nil {
+			log.WithError(err).Error("email change notification could not be sent")
+		}
+	}()
+
+	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+}
+
+// RevertEmailChange restores the previous email address and logs out every session and API token of the account,
+// as the change is assumed to have been made by someone else. Whoever it was may also have set the password,
+// so it is cleared and a reset link is sent to the restored address.
+func RevertEmailChange(c *fiber.Ctx) error {
+	var dto EmailTokenDTO
+	if err := lib.ParseAndValidate(c, &dto); err != nil {
+		return err
+	}
+
+	token, ok := lib.VerifySignedValue(dto.Token)
+	if !ok {
+		return lib.ErrInvalidToken
+	}
+
+	tx := lib.DB.Begin()
+
+	oneTimeToken, err := lib.ConsumeToken(tx, models.TokenEmailRevert, token)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	user := oneTimeToken.Connection.User
+
+	if err := changeEmail(tx, user, oneTimeToken.Data); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Where("connection_id IN (?)", lib.UserConnections(tx, user.ID)).
+		Delete(&models.Session{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := lib.RevokeAPIAccess(tx, user.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	connectionID := models.ProviderEmailType.WithID(user.Email)
+	result := tx.Model(&models.Connection{}).Where("id = ?", connectionID).Update("password", "")
+	if result.Error != nil {
+		tx.Rollback()
+		return result.Error
+	}
+
+	// Users who signed up through a provider have no password to reset.
+	var resetToken string
+	if result.RowsAffected > 0 {
+		if resetToken, err = issueResetLink(tx, connectionID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	if resetToken != "" {
+		sendResetLink(user, resetToken)
+	}
+
+	lib.ClearAuth(c)
+
+	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+}
+
+// changeEmail updates the user's email address and renames their email connection to match.
+// Everything referencing the connection is moved across, as the address is part of its ID.
+func changeEmail(tx *gorm.DB, user *models.User, email string) error {
+	var count int64
+	if err := tx.Model(models.User{}).Where("email = ? AND id <> ?", email, user.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return lib.ErrEmailExists
+	}
+
+	oldID := models.ProviderEmailType.WithID(user.Email)
+	newID := models.ProviderEmailType.WithID(email)
+
+	if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Update("email", email).Error; err != nil {
+		return err
+	}
+	user.Email = email
+
+	var connection models.Connection
+	if err := tx.Where(models.Connection{
+		BaseModel: models.BaseModel{ID: oldID},
+	}).First(&connection).Error; err != nil {
+		// Users who signed up through a provider have no email connection to rename.
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if err := tx.Create(&models.Connection{
+		BaseModel:  models.BaseModel{ID: newID},
+		UserID:     connection.UserID,
+		TOTPVerify: connection.TOTPVerify,
+		Password:   connection.Password,
+		Verified:   true, // The new address has been confirmed through its link
+	}).Error; err != nil {
+		return err
+	}
+
+	for _, model := range []any{&models.Session{}, &models.KnownDevice{}, &models.MFAChallenge{}, &models.OneTimeToken{}} {
+		if err := tx.Model(model).Where("connection_id = ?", oldID).Update("connection_id", newID).Error; err != nil {
+			return err
+		}
+	}
+
+	return tx.Delete(&connection).Error
+}
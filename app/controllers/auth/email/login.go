@@ -1,19 +1,22 @@
 package email
 
 import (
+	"errors"
 	"github.com/alexedwards/argon2id"
 	"github.com/gofiber/fiber/v2"
-	"net/http"
-	"time"
+	log "github.com/sirupsen/logrus"
 
 	"github.com/twibber/api/lib"
+	"github.com/twibber/api/mailer"
 	"github.com/twibber/api/models"
+	"gorm.io/gorm"
 )
 
 type LoginDTO struct {
-	Email    string `json:"email"     validate:"required,email,max=512"`
-	Password string `json:"password"  validate:"required,min=8"`
-	Captcha  string `json:"captcha"   validate:""`
+	Email      string `json:"email"       validate:"required,email,max=512"`
+	Password   string `json:"password"    validate:"required,min=8"`
+	RememberMe bool   `json:"remember_me"`
+	Captcha    string `json:"captcha"     validate:""`
 }
 
 func Login(c *fiber.Ctx) error {
@@ -22,7 +25,16 @@ func Login(c *fiber.Ctx) error {
 		return err
 	}
 
-	if err := lib.CheckCaptcha(dto.Captcha); err != nil {
+	if err := lib.CheckCaptcha(c, dto.Captcha, lib.CaptchaLogin); err != nil {
+		return err
+	}
+
+	// Refuse attempts from throttled addresses and accounts before checking the password.
+	account := lib.AccountKey(dto.Email)
+	if err := lib.LoginIPPolicy.Check(c.IP()); err != nil {
+		return err
+	}
+	if err := lib.LoginAccountPolicy.Check(account); err != nil {
 		return err
 	}
 
@@ -33,11 +45,20 @@ func Login(c *fiber.Ctx) error {
 		BaseModel: models.BaseModel{
 			ID: models.ProviderEmailType.WithID(dto.Email),
 		},
-	}).First(&connection).Error; err != nil {
+	}).Preload("User").First(&connection).Error; err != nil {
 		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return loginFailed(c, account, nil)
+		}
 		return err
 	}
 
+	// Passwords cleared when an email change is reverted cannot be used until they are reset.
+	if connection.Password == "" {
+		tx.Rollback()
+		return loginFailed(c, account, &connection)
+	}
+
 	match, err := argon2id.ComparePasswordAndHash(dto.Password, connection.Password)
 	if err != nil {
 		tx.Rollback()
@@ -46,31 +67,67 @@ func Login(c *fiber.Ctx) error {
 
 	if !match {
 		tx.Rollback()
+		return loginFailed(c, account, &connection)
+	}
+
+	if err := lib.LoginAccountPolicy.Reset(account); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return completeLogin(c, tx, &connection, dto.RememberMe)
+}
+
+// loginFailed records a failed login against the address and the account, and notifies the owner of the
+// account if it has just been locked. Unknown accounts are counted too, so they cannot be told apart.
+func loginFailed(c *fiber.Ctx, account string, connection *models.Connection) error {
+	if _, err := lib.LoginIPPolicy.Record(c.IP()); err != nil {
+		return err
+	}
+
+	locked, err := lib.LoginAccountPolicy.Record(account)
+	if err != nil {
+		return err
+	}
+
+	if !locked {
 		return lib.ErrInvalidCredentials
 	}
 
-	token := lib.GenerateString(64)
-	exp := 24 * time.Hour
+	if connection != nil {
+		ip := c.IP()
 
-	if err := tx.Create(&models.Session{
-		BaseModel: models.BaseModel{
-			ID: token,
-		},
-		ConnectionID: connection.ID,
-		Info: models.SessionInfo{
-			IPAddresses: c.IPs(),
-			UserAgent:   c.Get("User-Agent"),
-		},
-		ExpiresAt: time.Now().Add(exp),
-	}).Error; err != nil {
+		// concurrently notify the user of the lockout
+		go func() {
+			err := mailer.AccountLockedDTO{
+				Defaults: mailer.Defaults{
+					Email: connection.User.Email,
+					Name:  connection.User.Username,
+				},
+				IP:      ip,
+				Minutes: int(lib.LoginAccountPolicy.LockDuration.Minutes()),
+			}.Send()
+			if err != nil {
+				log.WithError(err).Error("account locked notification could not be sent")
+			}
+		}()
+	}
+
+	return lib.LoginAccountPolicy.LockError.WithRetryAfter(lib.LoginAccountPolicy.LockDuration)
+}
+
+// completeLogin issues a session for the connection, or an MFA challenge if the account has MFA enabled,
+// and commits the transaction. Remembered sessions use longer lifetimes and a persistent cookie.
+func completeLogin(c *fiber.Ctx, tx *gorm.DB, connection *models.Connection, rememberMe bool) error {
+	login, err := lib.CompleteLogin(tx, c, connection.ID, rememberMe)
+	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	tx.Commit()
-
-	// Set a cookie with the authorization token.
-	lib.SetAuth(c, token, exp)
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
 
-	return c.Status(http.StatusOK).JSON(lib.BlankSuccess)
+	return lib.SendLogin(c, login)
 }
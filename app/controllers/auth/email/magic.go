@@ -0,0 +1,105 @@
+package email
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	log "github.com/sirupsen/logrus"
+	cfg "github.com/twibber/api/config"
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/mailer"
+	"github.com/twibber/api/models"
+	"gorm.io/gorm"
+	"net/url"
+	"time"
+)
+
+// magicLinkDuration is how long a login link remains valid.
+const magicLinkDuration = 15 * time.Minute
+
+type MagicLinkDTO struct {
+	Email   string `json:"email"   validate:"required,email,max=512"`
+	Captcha string `json:"captcha" validate:""`
+}
+
+// SendMagicLink emails a single-use login link to the address if it belongs to an account.
+// The response is the same whether or not the account exists.
+func SendMagicLink(c *fiber.Ctx) error {
+	var dto MagicLinkDTO
+	if err := lib.ParseAndValidate(c, &dto); err != nil {
+		return err
+	}
+
+	if err := lib.CheckCaptcha(c, dto.Captcha, lib.CaptchaMagicLink); err != nil {
+		return err
+	}
+
+	var connection models.Connection
+	if err := lib.DB.Where(models.Connection{
+		BaseModel: models.BaseModel{
+			ID: models.ProviderEmailType.WithID(dto.Email),
+		},
+	}).Preload("User").First(&connection).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+		}
+		return err
+	}
+
+	token, err := lib.IssueToken(lib.DB, models.TokenMagicLink, connection.ID, "", magicLinkDuration)
+	if err != nil {
+		return err
+	}
+
+	// concurrently send login link to user
+	go func() {
+		err := mailer.MagicLinkDTO{
+			Defaults: mailer.Defaults{
+				Email: connection.User.Email,
+				Name:  connection.User.Username,
+			},
+			Link: cfg.Config.PublicURL + "/auth/magic?token=" + url.QueryEscape(lib.SignValue(token)),
+		}.Send()
+		if err != nil {
+			log.WithError(err).Error("login link could not be sent")
+		}
+	}()
+
+	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+}
+
+type ConsumeMagicLinkDTO struct {
+	Token      string `json:"token"       validate:"required"`
+	RememberMe bool   `json:"remember_me"`
+}
+
+// ConsumeMagicLink logs in using a login link, verifying the connection if it was not already.
+func ConsumeMagicLink(c *fiber.Ctx) error {
+	var dto ConsumeMagicLinkDTO
+	if err := lib.ParseAndValidate(c, &dto); err != nil {
+		return err
+	}
+
+	// Reject tampered links before touching the database.
+	token, ok := lib.VerifySignedValue(dto.Token)
+	if !ok {
+		return lib.ErrInvalidToken
+	}
+
+	tx := lib.DB.Begin()
+
+	oneTimeToken, err := lib.ConsumeToken(tx, models.TokenMagicLink, token)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Receiving the link proves ownership of the address.
+	if !oneTimeToken.Connection.Verified {
+		if err := tx.Model(oneTimeToken.Connection).Update("verified", true).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return completeLogin(c, tx, oneTimeToken.Connection, dto.RememberMe)
+}
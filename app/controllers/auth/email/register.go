@@ -9,7 +9,6 @@ import (
 	"github.com/twibber/api/models"
 	"net/http"
 	"strings"
-	"time"
 )
 
 // RegisterDTO defines the structure for registration request data.
@@ -30,7 +29,7 @@ func Register(c *fiber.Ctx) error {
 	}
 
 	// Check the provided captcha.
-	if err := lib.CheckCaptcha(dto.Captcha); err != nil {
+	if err := lib.CheckCaptcha(c, dto.Captcha, lib.CaptchaRegister); err != nil {
 		return err
 	}
 
@@ -93,7 +92,6 @@ func Register(c *fiber.Ctx) error {
 	user := models.User{
 		Username:       dto.Username,
 		DisplayName:    dto.Username,
-		Admin:          false,
 		VerifiedPerson: false,
 		Email:          dto.Email,
 		Suspended:      false,
@@ -102,27 +100,24 @@ func Register(c *fiber.Ctx) error {
 		return err
 	}
 
-	// Set expiration for the session.
-	exp := 24 * time.Hour
+	// Build the session, new accounts are not remembered until they log in with "remember me".
+	session, exp := lib.NewSession(c, token, false)
 
 	// Create a new connection record.
-	if err := tx.Create(&models.Connection{
+	connection := models.Connection{
 		BaseModel:  models.BaseModel{ID: models.ProviderEmailType.WithID(dto.Email)},
 		UserID:     user.ID,
 		Password:   hashedPassword,
 		TOTPVerify: totpCode,
 		Verified:   false,
-		Sessions: []models.Session{
-			{
-				BaseModel: models.BaseModel{ID: token},
-				Info: models.SessionInfo{
-					IPAddresses: c.IPs(),
-					UserAgent:   c.Get("User-Agent"),
-				},
-				ExpiresAt: time.Now().Add(exp),
-			},
-		},
-	}).Error; err != nil {
+		Sessions:   []models.Session{session},
+	}
+	if err := tx.Create(&connection).Error; err != nil {
+		return err
+	}
+
+	// Remember the device used to register, so later logins from it are not reported as new.
+	if _, _, err := lib.TrackDevice(tx, &connection.Sessions[0]); err != nil {
 		return err
 	}
 
@@ -20,6 +20,21 @@ func ResendCode(c *fiber.Ctx) error {
 		return err
 	}
 
+	// Every request counts, whether or not an email is sent, to stop the endpoint being used to flood an inbox.
+	account := lib.AccountKey(dto.Email)
+	if err := lib.ResendIPPolicy.Check(c.IP()); err != nil {
+		return err
+	}
+	if err := lib.ResendAccountPolicy.Check(account); err != nil {
+		return err
+	}
+	if _, err := lib.ResendIPPolicy.Record(c.IP()); err != nil {
+		return err
+	}
+	if _, err := lib.ResendAccountPolicy.Record(account); err != nil {
+		return err
+	}
+
 	code := strings.Split(utils.UUIDv4(), "-")[0]
 
 	var connection models.Connection
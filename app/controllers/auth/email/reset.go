@@ -0,0 +1,151 @@
+package email
+
+import (
+	"errors"
+	"github.com/alexedwards/argon2id"
+	"github.com/gofiber/fiber/v2"
+	log "github.com/sirupsen/logrus"
+	cfg "github.com/twibber/api/config"
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/mailer"
+	"github.com/twibber/api/models"
+	"gorm.io/gorm"
+	"net/url"
+	"time"
+)
+
+// resetDuration is how long a password reset link remains valid.
+const resetDuration = time.Hour
+
+type ForgotDTO struct {
+	Email   string `json:"email"   validate:"required,email,max=512"`
+	Captcha string `json:"captcha" validate:""`
+}
+
+// ForgotPassword sends a password reset link to the email address if it belongs to an account.
+// The response is the same whether or not the account exists.
+func ForgotPassword(c *fiber.Ctx) error {
+	var dto ForgotDTO
+	if err := lib.ParseAndValidate(c, &dto); err != nil {
+		return err
+	}
+
+	if err := lib.CheckCaptcha(c, dto.Captcha, lib.CaptchaForgot); err != nil {
+		return err
+	}
+
+	var connection models.Connection
+	if err := lib.DB.Where(models.Connection{
+		BaseModel: models.BaseModel{
+			ID: models.ProviderEmailType.WithID(dto.Email),
+		},
+	}).Preload("User").First(&connection).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+		}
+		return err
+	}
+
+	tx := lib.DB.Begin()
+
+	token, err := issueResetLink(tx, connection.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	sendResetLink(connection.User, token)
+
+	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+}
+
+// issueResetLink issues a password reset token for the connection, revoking any issued before it.
+func issueResetLink(tx *gorm.DB, connectionID string) (string, error) {
+	// Only the most recently requested link can be used.
+	if err := lib.RevokeTokens(tx, models.TokenPasswordReset, connectionID); err != nil {
+		return "", err
+	}
+
+	return lib.IssueToken(tx, models.TokenPasswordReset, connectionID, "", resetDuration)
+}
+
+// sendResetLink concurrently sends the password reset link to the user.
+func sendResetLink(user *models.User, token string) {
+	go func() {
+		err := mailer.PasswordResetDTO{
+			Defaults: mailer.Defaults{
+				Email: user.Email,
+				Name:  user.Username,
+			},
+			Link: cfg.Config.PublicURL + "/reset-password?token=" + url.QueryEscape(token),
+		}.Send()
+		if err != nil {
+			log.WithError(err).Error("password reset email could not be sent")
+		}
+	}()
+}
+
+type ResetDTO struct {
+	Token    string `json:"token"    validate:"required"`
+	Password string `json:"password" validate:"required,min=8"`
+}
+
+// ResetPassword sets a new password using a reset link, and revokes every session on the connection
+// along with the account's API tokens.
+func ResetPassword(c *fiber.Ctx) error {
+	var dto ResetDTO
+	if err := lib.ParseAndValidate(c, &dto); err != nil {
+		return err
+	}
+
+	tx := lib.DB.Begin()
+
+	// The token is checked before hashing, so the expensive hash cannot be triggered without a valid link.
+	token, err := lib.ConsumeToken(tx, models.TokenPasswordReset, dto.Token)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	hashedPassword, err := argon2id.CreateHash(dto.Password, &lib.ArgonConfig)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Following the link proves ownership of the address, so the connection is verified too.
+	if err := tx.Model(&models.Connection{}).Where("id = ?", token.ConnectionID).Updates(map[string]any{
+		"password": hashedPassword,
+		"verified": true,
+	}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Where(models.Session{ConnectionID: token.ConnectionID}).Delete(&models.Session{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := lib.RevokeAPIAccess(tx, token.Connection.UserID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := lib.RevokeTokens(tx, models.TokenPasswordReset, token.ConnectionID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	lib.ClearAuth(c)
+
+	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+}
@@ -7,13 +7,13 @@ import (
 )
 
 func Logout(c *fiber.Ctx) error {
-	authCookie := c.Cookies("Authorization")
+	authToken := lib.GetAuthToken(c)
 
 	lib.ClearAuth(c)
 
-	lib.DB.Delete(&models.Session{
-		BaseModel: models.BaseModel{ID: authCookie},
-	})
+	if authToken != "" {
+		lib.DB.Where(models.Session{TokenHash: lib.HashToken(authToken)}).Delete(&models.Session{})
+	}
 
 	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
 }
@@ -0,0 +1,115 @@
+package mfa
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/models"
+	"gorm.io/gorm"
+	"regexp"
+	"time"
+)
+
+// maxAttempts is the number of incorrect codes allowed before a challenge is discarded.
+// Failures are also counted against the user by lib.MFAUserPolicy, which locks the account across challenges.
+const maxAttempts = 5
+
+// totpCode matches codes generated by an authenticator app.
+var totpCode = regexp.MustCompile(`^[0-9]{6}$`)
+
+// ErrInvalidChallenge is returned when the challenge token is unknown, expired or exhausted.
+var ErrInvalidChallenge = lib.NewError(fiber.StatusUnauthorized, "The login has expired. Please log in again.", nil, "INVALID_MFA_CHALLENGE")
+
+type VerifyDTO struct {
+	Challenge string `json:"challenge" validate:"required"`
+	Code      string `json:"code"      validate:"required"`
+}
+
+// Verify completes a login awaiting MFA by checking the code, or a recovery code, and issuing a session.
+func Verify(c *fiber.Ctx) error {
+	var dto VerifyDTO
+	if err := lib.ParseAndValidate(c, &dto); err != nil {
+		return err
+	}
+
+	tx := lib.DB.Begin()
+
+	var challenge models.MFAChallenge
+	if err := tx.Where(models.MFAChallenge{
+		BaseModel: models.BaseModel{
+			ID: lib.HashToken(dto.Challenge),
+		},
+	}).Preload("Connection").Preload("Connection.User").First(&challenge).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidChallenge
+		}
+		return err
+	}
+
+	if challenge.ExpiresAt.Before(time.Now()) || challenge.Attempts >= maxAttempts {
+		tx.Delete(&challenge)
+		tx.Commit()
+		return ErrInvalidChallenge
+	}
+
+	user := challenge.Connection.User
+
+	// Refuse users locked out by failures on earlier challenges before checking the code.
+	if err := lib.MFAUserPolicy.Check(user.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Six digit codes come from the authenticator app, anything else is treated as a recovery code.
+	var err error
+	if totpCode.MatchString(dto.Code) {
+		err = lib.UseMFACode(tx, user, user.MFA, dto.Code)
+	} else {
+		err = lib.UseRecoveryCode(tx, user.ID, dto.Code, c.IP())
+	}
+
+	if err != nil {
+		tx.Rollback()
+
+		if err != lib.ErrInvalidMFACode {
+			return err
+		}
+
+		// Failed attempts are counted outside the transaction so they are not rolled back.
+		lib.DB.Model(&challenge).Update("attempts", gorm.Expr("attempts + 1"))
+
+		locked, recordErr := lib.MFAUserPolicy.Record(user.ID)
+		if recordErr != nil {
+			return recordErr
+		}
+		if locked {
+			return lib.MFAUserPolicy.LockError.WithRetryAfter(lib.MFAUserPolicy.LockDuration)
+		}
+
+		return err
+	}
+
+	if err := lib.MFAUserPolicy.Reset(user.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// The challenge is single use.
+	if err := tx.Delete(&challenge).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	login, err := lib.CreateSession(tx, c, challenge.ConnectionID, challenge.RememberMe)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	return lib.SendLogin(c, login)
+}
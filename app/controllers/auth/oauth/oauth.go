@@ -0,0 +1,283 @@
+package oauth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	log "github.com/sirupsen/logrus"
+	cfg "github.com/twibber/api/config"
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/models"
+	"gorm.io/gorm"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Intents describe what the callback should do with the provider account.
+const (
+	IntentLogin = "login" // Log in to, or sign up with, the provider account
+	IntentLink  = "link"  // Attach the provider account to the currently logged-in user
+)
+
+// ErrInvalidState is returned when the OAuth callback does not match the flow started by the client.
+var ErrInvalidState = lib.NewError(fiber.StatusBadRequest, "The login request has expired or is invalid. Please try again.", nil, "INVALID_OAUTH_STATE")
+
+// ErrProviderFailed is returned when the provider could not complete the flow, such as when the code is rejected.
+var ErrProviderFailed = lib.NewError(fiber.StatusBadRequest, "The provider could not complete the login. Please try again.", nil, "OAUTH_PROVIDER_ERROR")
+
+// ErrEmailUnverified is returned when the provider has not verified the email address of the account.
+var ErrEmailUnverified = lib.NewError(fiber.StatusBadRequest, "The provider has not verified the email address of this account.", nil, "OAUTH_EMAIL_UNVERIFIED")
+
+// ErrLinkRequired is returned when the provider account's address belongs to a user who has not verified it,
+// who must log in and link the provider account from their account settings instead.
+var ErrLinkRequired = lib.NewError(fiber.StatusConflict, "An account with this email address already exists. Log in to it and connect this provider from your account settings.", nil, "OAUTH_LINK_REQUIRED")
+
+// ErrConnectionInUse is returned when linking a provider account which already belongs to another user.
+var ErrConnectionInUse = lib.NewError(fiber.StatusConflict, "This account is already connected to another user.", nil, "CONNECTION_IN_USE")
+
+// Redirect starts the authorization code flow for the provider by redirecting the user to it.
+func Redirect(connType models.ConnectionType) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		provider, ok := lib.GetOAuthProvider(connType)
+		if !ok {
+			return lib.ErrNotFound
+		}
+
+		return c.Redirect(Begin(c, provider, IntentLogin, ""), fiber.StatusFound)
+	}
+}
+
+// Begin generates the state and PKCE verifier for a new flow, stores them in a signed cookie and returns the authorization URL.
+// Links are bound to the user starting them, who must be the one signed in when the flow completes.
+func Begin(c *fiber.Ctx, provider *lib.OAuthProvider, intent, userID string) string {
+	state := lib.GenerateString(32)
+	verifier := lib.GenerateString(64)
+
+	lib.SetOAuthState(c, lib.SignValue(state+"."+verifier+"."+intent+"."+userID))
+
+	return provider.AuthCodeURL(state, verifier)
+}
+
+// Callback completes the authorization code flow, creating or linking the connection and issuing a session,
+// or an MFA challenge if the account has MFA enabled.
+func Callback(connType models.ConnectionType) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		provider, ok := lib.GetOAuthProvider(connType)
+		if !ok {
+			return lib.ErrNotFound
+		}
+
+		// The state cookie is single use, regardless of the outcome of the callback.
+		stateCookie, ok := lib.VerifySignedValue(c.Cookies("OAuthState"))
+		lib.ClearOAuthState(c)
+		if !ok {
+			return ErrInvalidState
+		}
+
+		parts := strings.SplitN(stateCookie, ".", 4)
+		if len(parts) != 4 || subtle.ConstantTimeCompare([]byte(parts[0]), []byte(c.Query("state"))) != 1 {
+			return ErrInvalidState
+		}
+
+		if c.Query("error") != "" {
+			return lib.NewError(fiber.StatusBadRequest, "The login was cancelled or denied by the provider.", nil, "OAUTH_DENIED")
+		}
+
+		accessToken, err := provider.Exchange(c.Query("code"), parts[1])
+		if err != nil {
+			log.WithError(err).WithField("provider", provider.Type).Warn("oauth code exchange failed")
+			return ErrProviderFailed
+		}
+
+		profile, err := provider.User(accessToken)
+		if err != nil {
+			log.WithError(err).WithField("provider", provider.Type).Warn("oauth user could not be fetched")
+			return ErrProviderFailed
+		}
+
+		if parts[2] == IntentLink {
+			return link(c, provider.Type, profile, parts[3])
+		}
+
+		tx := lib.DB.Begin()
+
+		connection, err := findOrCreateConnection(tx, provider.Type, profile)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		login, err := lib.CompleteLogin(tx, c, connection.ID, false)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			return err
+		}
+
+		// The browser arrives here by redirect, so it is sent to the client to answer the MFA challenge.
+		if login.Challenge != "" {
+			return c.Redirect(cfg.Config.PublicURL+"/auth/mfa?challenge="+url.QueryEscape(login.Challenge), fiber.StatusFound)
+		}
+
+		// Set a cookie with the authorization token.
+		lib.SetAuth(c, login.Token, login.MaxAge)
+		login.Notify()
+
+		return c.Redirect(cfg.Config.PublicURL, fiber.StatusFound)
+	}
+}
+
+// link attaches the provider account to the user of the current session, who must have started the link.
+// The callback is not behind the auth middleware, so the session is authenticated and audited here.
+func link(c *fiber.Ctx, connType models.ConnectionType, profile *lib.OAuthUser, userID string) error {
+	session, err := lib.Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	if session.Connection.UserID != userID {
+		return ErrInvalidState
+	}
+
+	return lib.AuditImpersonation(c, session, func() error {
+		// Staff impersonating the user cannot link accounts.
+		if session.ImpersonatorID != nil {
+			return lib.ErrImpersonating
+		}
+
+		return linkConnection(c, connType, profile, session.Connection.UserID)
+	})
+}
+
+// linkConnection creates the connection for the provider account, unless it already belongs to another user.
+func linkConnection(c *fiber.Ctx, connType models.ConnectionType, profile *lib.OAuthUser, userID string) error {
+	var connection models.Connection
+	err := lib.DB.Where(models.Connection{
+		BaseModel: models.BaseModel{
+			ID: connType.WithID(profile.ID),
+		},
+	}).First(&connection).Error
+	if err == nil {
+		if connection.UserID != userID {
+			return ErrConnectionInUse
+		}
+	} else if errors.Is(err, gorm.ErrRecordNotFound) {
+		if err := lib.DB.Create(&models.Connection{
+			BaseModel: models.BaseModel{ID: connType.WithID(profile.ID)},
+			UserID:    userID,
+			Verified:  profile.EmailVerified,
+		}).Error; err != nil {
+			return err
+		}
+	} else {
+		return err
+	}
+
+	return c.Redirect(cfg.Config.PublicURL, fiber.StatusFound)
+}
+
+// findOrCreateConnection returns the connection for the provider account, linking it to an existing user
+// with the same email address or creating a new user when none exists. Either way the provider must have verified the address,
+// and an existing user must have verified it too.
+func findOrCreateConnection(tx *gorm.DB, connType models.ConnectionType, profile *lib.OAuthUser) (*models.Connection, error) {
+	var connection models.Connection
+	err := tx.Where(models.Connection{
+		BaseModel: models.BaseModel{
+			ID: connType.WithID(profile.ID),
+		},
+	}).First(&connection).Error
+	if err == nil {
+		return &connection, nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if profile.Email == "" {
+		return nil, lib.NewError(fiber.StatusBadRequest, "The provider did not share an email address for this account.", nil, "OAUTH_NO_EMAIL")
+	}
+
+	// An unverified address could belong to someone else, so it can neither claim nor sign up with it.
+	if !profile.EmailVerified {
+		return nil, ErrEmailUnverified
+	}
+
+	var user models.User
+	err = tx.Where(models.User{Email: profile.Email}).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		username, err := uniqueUsername(tx, profile.Username)
+		if err != nil {
+			return nil, err
+		}
+
+		displayName := profile.Name
+		if displayName == "" {
+			displayName = username
+		}
+
+		user = models.User{
+			Username:    username,
+			DisplayName: displayName,
+			Avatar:      profile.Avatar,
+			Email:       profile.Email,
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else {
+		// Addresses can be registered without verifying them, so the provider account is only linked to a user
+		// who has verified theirs, rather than to whoever registered the address first.
+		var unverified int64
+		if err := tx.Model(&models.Connection{}).
+			Where("id = ? AND NOT verified", models.ProviderEmailType.WithID(user.Email)).
+			Count(&unverified).Error; err != nil {
+			return nil, err
+		}
+
+		if unverified > 0 {
+			return nil, ErrLinkRequired
+		}
+	}
+
+	connection = models.Connection{
+		BaseModel: models.BaseModel{ID: connType.WithID(profile.ID)},
+		UserID:    user.ID,
+		Verified:  profile.EmailVerified,
+	}
+	if err := tx.Create(&connection).Error; err != nil {
+		return nil, err
+	}
+
+	return &connection, nil
+}
+
+var usernameStrip = regexp.MustCompile(`[^a-z0-9_]`)
+
+// uniqueUsername derives an unused username from the one suggested by the provider.
+func uniqueUsername(tx *gorm.DB, base string) (string, error) {
+	base = usernameStrip.ReplaceAllString(strings.ToLower(base), "")
+	if len(base) > 24 {
+		base = base[:24]
+	}
+	if len(base) < 3 {
+		base = "user" + base
+	}
+
+	username := base
+	for {
+		var count int64
+		if err := tx.Model(models.User{}).Where(models.User{Username: username}).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return username, nil
+		}
+
+		username = base + strings.ToLower(lib.GenerateString(6))
+	}
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/models"
+	"strings"
+)
+
+type RevokeSessionDTO struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// RevokeSession ends the session reported in a new sign-in email and forgets its device,
+// so that another login from it is reported again.
+func RevokeSession(c *fiber.Ctx) error {
+	var dto RevokeSessionDTO
+	if err := lib.ParseAndValidate(c, &dto); err != nil {
+		return err
+	}
+
+	token, ok := lib.VerifySignedValue(dto.Token)
+	if !ok {
+		return lib.ErrInvalidToken
+	}
+
+	tx := lib.DB.Begin()
+
+	oneTimeToken, err := lib.ConsumeToken(tx, models.TokenSessionRevoke, token)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	fingerprint, sessionID, _ := strings.Cut(oneTimeToken.Data, ":")
+
+	if err := tx.Where("id = ? AND connection_id = ?", sessionID, oneTimeToken.ConnectionID).
+		Delete(&models.Session{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Where("connection_id = ? AND fingerprint = ?", oneTimeToken.ConnectionID, fingerprint).
+		Delete(&models.KnownDevice{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+}
@@ -0,0 +1,223 @@
+package webauthn
+
+import (
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/models"
+	"net/http"
+)
+
+// CeremonyResponse is returned when a ceremony is started, the options are passed to the browser's WebAuthn API.
+type CeremonyResponse struct {
+	Ceremony string `json:"ceremony"` // Token to send back when finishing the ceremony
+	Options  any    `json:"options"`  // Options for navigator.credentials.create() or get()
+}
+
+// BeginRegistration starts registering a passkey for the authenticated user.
+func BeginRegistration(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	wa, err := lib.NewWebAuthn()
+	if err != nil {
+		return err
+	}
+
+	user, err := lib.LoadWebAuthnUser(lib.DB, session.Connection.UserID)
+	if err != nil {
+		return err
+	}
+
+	options, data, err := lib.BeginWebAuthnRegistration(wa, user)
+	if err != nil {
+		return err
+	}
+
+	ceremony, err := lib.SaveWebAuthnCeremony(lib.DB, &session.Connection.UserID, data)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(http.StatusOK).JSON(lib.Response{
+		Success: true,
+		Data: CeremonyResponse{
+			Ceremony: ceremony,
+			Options:  options,
+		},
+	})
+}
+
+type FinishRegistrationDTO struct {
+	Ceremony   string          `json:"ceremony"   validate:"required"`
+	Name       string          `json:"name"       validate:"omitempty,max=64"`
+	Credential json.RawMessage `json:"credential" validate:"required"`
+}
+
+// FinishRegistration verifies the new passkey and stores it as a webauthn connection of the authenticated user.
+func FinishRegistration(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	var dto FinishRegistrationDTO
+	if err := lib.ParseAndValidate(c, &dto); err != nil {
+		return err
+	}
+
+	wa, err := lib.NewWebAuthn()
+	if err != nil {
+		return err
+	}
+
+	tx := lib.DB.Begin()
+
+	ceremony, data, err := lib.TakeWebAuthnCeremony(tx, dto.Ceremony)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if ceremony.UserID == nil || *ceremony.UserID != session.Connection.UserID {
+		tx.Rollback()
+		return lib.ErrInvalidPasskey
+	}
+
+	user, err := lib.LoadWebAuthnUser(tx, session.Connection.UserID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	credential, err := lib.FinishWebAuthnRegistration(wa, user, *data, dto.Credential)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	transports := make([]string, len(credential.Transport))
+	for i, transport := range credential.Transport {
+		transports[i] = string(transport)
+	}
+
+	// Passkeys inherit the verification state of the connection used to add them.
+	connection := models.Connection{
+		BaseModel: models.BaseModel{ID: lib.WebAuthnConnectionID(credential.ID)},
+		UserID:    session.Connection.UserID,
+		Verified:  session.Connection.Verified,
+	}
+	if err := tx.Create(&connection).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Create(&models.WebAuthnCredential{
+		ConnectionID:    connection.ID,
+		Name:            dto.Name,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		Transports:      transports,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+	}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	return c.Status(http.StatusCreated).JSON(lib.Response{
+		Success: true,
+		Data:    connection,
+	})
+}
+
+// BeginLogin starts a usernameless passkey login.
+func BeginLogin(c *fiber.Ctx) error {
+	wa, err := lib.NewWebAuthn()
+	if err != nil {
+		return err
+	}
+
+	options, data, err := lib.BeginWebAuthnLogin(wa)
+	if err != nil {
+		return err
+	}
+
+	ceremony, err := lib.SaveWebAuthnCeremony(lib.DB, nil, data)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(http.StatusOK).JSON(lib.Response{
+		Success: true,
+		Data: CeremonyResponse{
+			Ceremony: ceremony,
+			Options:  options,
+		},
+	})
+}
+
+type FinishLoginDTO struct {
+	Ceremony   string          `json:"ceremony"    validate:"required"`
+	Credential json.RawMessage `json:"credential"  validate:"required"`
+	RememberMe bool            `json:"remember_me"`
+}
+
+// FinishLogin verifies the passkey assertion and issues a session for its connection, or an MFA challenge.
+func FinishLogin(c *fiber.Ctx) error {
+	var dto FinishLoginDTO
+	if err := lib.ParseAndValidate(c, &dto); err != nil {
+		return err
+	}
+
+	wa, err := lib.NewWebAuthn()
+	if err != nil {
+		return err
+	}
+
+	tx := lib.DB.Begin()
+
+	ceremony, data, err := lib.TakeWebAuthnCeremony(tx, dto.Ceremony)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if ceremony.UserID != nil {
+		tx.Rollback()
+		return lib.ErrInvalidPasskey
+	}
+
+	_, credential, err := lib.FinishWebAuthnLogin(wa, *data, dto.Credential, func(userID string) (*lib.WebAuthnUser, error) {
+		return lib.LoadWebAuthnUser(tx, userID)
+	})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	connectionID := lib.WebAuthnConnectionID(credential.ID)
+
+	if err := tx.Model(&models.WebAuthnCredential{}).
+		Where(models.WebAuthnCredential{ConnectionID: connectionID}).
+		Update("sign_count", credential.Authenticator.SignCount).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// A passkey is a first factor like any other, so accounts with MFA enabled must still answer a challenge.
+	login, err := lib.CompleteLogin(tx, c, connectionID, dto.RememberMe)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	return lib.SendLogin(c, login)
+}
@@ -0,0 +1,221 @@
+package oauthserver
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/url"
+)
+
+type AuthorizeDTO struct {
+	ResponseType        string `json:"response_type"         query:"response_type"`
+	ClientID            string `json:"client_id"             query:"client_id"`
+	RedirectURI         string `json:"redirect_uri"          query:"redirect_uri"`
+	Scope               string `json:"scope"                 query:"scope"`
+	State               string `json:"state"                 query:"state"`
+	CodeChallenge       string `json:"code_challenge"        query:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method" query:"code_challenge_method"`
+	Approve             bool   `json:"approve"`
+}
+
+// AuthorizeResponse describes the consent screen to show the user.
+type AuthorizeResponse struct {
+	Client     *models.OAuthClient `json:"client"`
+	Scopes     []string            `json:"scopes"`
+	Authorized bool                `json:"authorized"` // Whether the user has already granted every requested scope
+}
+
+// RedirectResponse holds the URL the user should be sent back to the client with.
+type RedirectResponse struct {
+	RedirectURL string `json:"redirect_url"`
+}
+
+var errInvalidClient = lib.NewError(fiber.StatusBadRequest, "The application or redirect URI is not valid.", nil, "INVALID_CLIENT")
+
+// validateAuthorize checks an authorization request, returning the client and the requested scopes.
+// Errors with the client or redirect URI are returned directly, as the user cannot safely be redirected,
+// while any other problem is returned as an OAuth error code to send back to the client.
+func validateAuthorize(dto AuthorizeDTO) (*models.OAuthClient, []string, string, error) {
+	client, err := lib.GetOAuthClient(lib.DB, dto.ClientID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, "", errInvalidClient
+		}
+		return nil, nil, "", err
+	}
+
+	if !lib.ValidRedirectURI(client, dto.RedirectURI) {
+		return nil, nil, "", errInvalidClient
+	}
+
+	if dto.ResponseType != "code" {
+		return client, nil, "unsupported_response_type", nil
+	}
+
+	// PKCE is required of every client, confidential or not.
+	if dto.CodeChallenge == "" || dto.CodeChallengeMethod != "S256" {
+		return client, nil, "invalid_request", nil
+	}
+
+	scopes, ok := lib.ParseScopes(dto.Scope)
+	if !ok {
+		return client, nil, "invalid_scope", nil
+	}
+
+	return client, scopes, "", nil
+}
+
+// redirectURL builds the URL sending the user back to the client with the given parameters.
+func redirectURL(redirectURI string, params url.Values) (string, error) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	for key, values := range params {
+		for _, value := range values {
+			query.Add(key, value)
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// redirectError responds with a redirect sending the OAuth error code back to the client.
+func redirectError(c *fiber.Ctx, dto AuthorizeDTO, code string) error {
+	params := url.Values{"error": {code}}
+	if dto.State != "" {
+		params.Set("state", dto.State)
+	}
+
+	redirect, err := redirectURL(dto.RedirectURI, params)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.Response{
+		Success: true,
+		Data:    RedirectResponse{RedirectURL: redirect},
+	})
+}
+
+// GetAuthorize handles the request to validate an authorization request and describe the consent screen.
+func GetAuthorize(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	var dto AuthorizeDTO
+	if err := c.QueryParser(&dto); err != nil {
+		return err
+	}
+
+	client, scopes, code, err := validateAuthorize(dto)
+	if err != nil {
+		return err
+	}
+	if code != "" {
+		return redirectError(c, dto, code)
+	}
+
+	var authorization models.OAuthAuthorization
+	authorized := true
+	if err := lib.DB.Where(models.OAuthAuthorization{
+		UserID:   session.Connection.UserID,
+		ClientID: client.ID,
+	}).First(&authorization).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		authorized = false
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.Response{
+		Success: true,
+		Data: AuthorizeResponse{
+			Client:     client,
+			Scopes:     scopes,
+			Authorized: authorized && lib.HasScopes(authorization.Scopes, scopes...),
+		},
+	})
+}
+
+// Authorize handles the user's decision on the consent screen, issuing an authorization code if they approved.
+func Authorize(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	var dto AuthorizeDTO
+	if err := lib.ParseAndValidate(c, &dto); err != nil {
+		return err
+	}
+
+	client, scopes, code, err := validateAuthorize(dto)
+	if err != nil {
+		return err
+	}
+	if code != "" {
+		return redirectError(c, dto, code)
+	}
+
+	if !dto.Approve {
+		return redirectError(c, dto, "access_denied")
+	}
+
+	tx := lib.DB.Begin()
+
+	// Consent is remembered, widening any scopes the user granted the client before.
+	var authorization models.OAuthAuthorization
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where(models.OAuthAuthorization{
+		UserID:   session.Connection.UserID,
+		ClientID: client.ID,
+	}).First(&authorization).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			tx.Rollback()
+			return err
+		}
+
+		authorization = models.OAuthAuthorization{
+			UserID:   session.Connection.UserID,
+			ClientID: client.ID,
+		}
+	}
+
+	for _, scope := range scopes {
+		if !lib.HasScopes(authorization.Scopes, scope) {
+			authorization.Scopes = append(authorization.Scopes, scope)
+		}
+	}
+
+	if err := tx.Save(&authorization).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	authCode, err := lib.IssueOAuthCode(tx, client, session.Connection.UserID, dto.RedirectURI, scopes, dto.CodeChallenge)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	params := url.Values{"code": {authCode}}
+	if dto.State != "" {
+		params.Set("state", dto.State)
+	}
+
+	redirect, err := redirectURL(dto.RedirectURI, params)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.Response{
+		Success: true,
+		Data:    RedirectResponse{RedirectURL: redirect},
+	})
+}
@@ -0,0 +1,89 @@
+package oauthserver
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/models"
+)
+
+type CreateClientDTO struct {
+	Name         string   `json:"name"          validate:"required,max=64"`
+	Website      string   `json:"website"       validate:"omitempty,url,max=255"`
+	RedirectURIs []string `json:"redirect_uris" validate:"required,min=1,max=10,dive,url"`
+	Public       bool     `json:"public"`
+}
+
+// CreateClientResponse holds a newly registered client, the secret is only ever returned here.
+type CreateClientResponse struct {
+	Client *models.OAuthClient `json:"client"`
+	Secret string              `json:"client_secret,omitempty"`
+}
+
+// ListClients handles the request to list the OAuth clients registered by the authenticated user.
+func ListClients(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	var clients []models.OAuthClient
+	if err := lib.DB.Where(models.OAuthClient{
+		OwnerID: session.Connection.UserID,
+	}).Order("created_at DESC").Find(&clients).Error; err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.Response{
+		Success: true,
+		Data:    clients,
+	})
+}
+
+// CreateClient handles the request to register an OAuth client owned by the authenticated user.
+func CreateClient(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	var dto CreateClientDTO
+	if err := lib.ParseAndValidate(c, &dto); err != nil {
+		return err
+	}
+
+	client := models.OAuthClient{
+		OwnerID:      session.Connection.UserID,
+		Name:         dto.Name,
+		Website:      dto.Website,
+		RedirectURIs: dto.RedirectURIs,
+		Public:       dto.Public,
+	}
+
+	secret, err := lib.CreateOAuthClient(lib.DB, &client)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(lib.Response{
+		Success: true,
+		Data: CreateClientResponse{
+			Client: &client,
+			Secret: secret,
+		},
+	})
+}
+
+// DeleteClient handles the request to delete an OAuth client, revoking every token issued to it.
+func DeleteClient(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	result := lib.DB.Where(models.OAuthClient{
+		BaseModel: models.BaseModel{
+			ID: c.Params("client"),
+		},
+		OwnerID: session.Connection.UserID,
+	}).Delete(&models.OAuthClient{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return lib.ErrNotFound
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+}
@@ -0,0 +1,235 @@
+package oauthserver
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/models"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// TokenDTO holds the parameters of the token endpoints, which may be sent as a form or as JSON.
+type TokenDTO struct {
+	GrantType    string `json:"grant_type"    form:"grant_type"`
+	Code         string `json:"code"          form:"code"`
+	RedirectURI  string `json:"redirect_uri"  form:"redirect_uri"`
+	CodeVerifier string `json:"code_verifier" form:"code_verifier"`
+	RefreshToken string `json:"refresh_token" form:"refresh_token"`
+	Token        string `json:"token"         form:"token"`
+	ClientID     string `json:"client_id"     form:"client_id"`
+	ClientSecret string `json:"client_secret" form:"client_secret"`
+}
+
+// IntrospectResponse describes a token as defined by RFC 7662, only active is set for unknown tokens.
+type IntrospectResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+}
+
+// parseToken parses the request and authenticates the client, using HTTP Basic credentials if given.
+func parseToken(c *fiber.Ctx) (*TokenDTO, *models.OAuthClient, error) {
+	var dto TokenDTO
+	if err := c.BodyParser(&dto); err != nil {
+		return nil, nil, lib.ErrOAuthInvalidRequest
+	}
+
+	if id, secret, ok := basicAuth(c); ok {
+		dto.ClientID, dto.ClientSecret = id, secret
+	}
+
+	client, err := lib.AuthenticateOAuthClient(lib.DB, dto.ClientID, dto.ClientSecret)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &dto, client, nil
+}
+
+// basicAuth returns the client credentials from the Authorization header, if present.
+func basicAuth(c *fiber.Ctx) (string, string, bool) {
+	header := c.Get(fiber.HeaderAuthorization)
+	if !strings.HasPrefix(header, "Basic ") {
+		return "", "", false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(header, "Basic "))
+	if err != nil {
+		return "", "", false
+	}
+
+	id, secret, ok := strings.Cut(string(decoded), ":")
+	return id, secret, ok
+}
+
+// Token handles the request to exchange an authorization code or refresh token for a new pair of tokens.
+func Token(c *fiber.Ctx) error {
+	dto, client, err := parseToken(c)
+	if err != nil {
+		return err
+	}
+
+	tx := lib.DB.Begin()
+
+	var res *lib.OAuthTokenResponse
+	switch dto.GrantType {
+	case "authorization_code":
+		if dto.Code == "" || dto.CodeVerifier == "" {
+			tx.Rollback()
+			return lib.ErrOAuthInvalidRequest
+		}
+
+		code, err := lib.ExchangeOAuthCode(tx, client, dto.Code, dto.RedirectURI, dto.CodeVerifier)
+		if err != nil {
+			// The code is consumed even if it was misused, so it cannot be tried again.
+			if errors.Is(err, lib.ErrOAuthInvalidGrant) {
+				tx.Commit()
+			} else {
+				tx.Rollback()
+			}
+			return err
+		}
+
+		res, err = lib.IssueOAuthTokens(tx, client, code.UserID, code.Scopes)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	case "refresh_token":
+		if dto.RefreshToken == "" {
+			tx.Rollback()
+			return lib.ErrOAuthInvalidRequest
+		}
+
+		res, err = lib.RefreshOAuthTokens(tx, client, dto.RefreshToken)
+		if err != nil {
+			if errors.Is(err, lib.ErrOAuthInvalidGrant) {
+				tx.Commit()
+			} else {
+				tx.Rollback()
+			}
+			return err
+		}
+	default:
+		tx.Rollback()
+		return lib.ErrOAuthUnsupported
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Status(fiber.StatusOK).JSON(res)
+}
+
+// Revoke handles the request from a client to revoke one of its tokens.
+// The response is the same whether or not the token existed, as required by RFC 7009.
+func Revoke(c *fiber.Ctx) error {
+	dto, client, err := parseToken(c)
+	if err != nil {
+		return err
+	}
+
+	if dto.Token != "" {
+		if err := lib.RevokeOAuthToken(lib.DB, client, dto.Token); err != nil {
+			return err
+		}
+	}
+
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// Introspect handles the request from a client to check whether one of its tokens is active.
+func Introspect(c *fiber.Ctx) error {
+	dto, client, err := parseToken(c)
+	if err != nil {
+		return err
+	}
+
+	res, err := introspect(client, dto.Token)
+	if err != nil {
+		return err
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Status(fiber.StatusOK).JSON(res)
+}
+
+// introspect looks up an access or refresh token, tokens issued to other clients are reported as inactive.
+func introspect(client *models.OAuthClient, token string) (*IntrospectResponse, error) {
+	inactive := &IntrospectResponse{Active: false}
+	if token == "" {
+		return inactive, nil
+	}
+
+	if lib.IsAPIToken(token) {
+		var apiToken models.APIToken
+		if err := lib.DB.Where(models.APIToken{
+			TokenHash: lib.HashToken(token),
+			ClientID:  &client.ID,
+		}).Preload("User").First(&apiToken).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return inactive, nil
+			}
+			return nil, err
+		}
+
+		if apiToken.ExpiresAt != nil && apiToken.ExpiresAt.Before(time.Now()) {
+			return inactive, nil
+		}
+
+		res := &IntrospectResponse{
+			Active:    true,
+			Scope:     strings.Join(apiToken.Scopes, " "),
+			ClientID:  client.ID,
+			Subject:   apiToken.UserID,
+			IssuedAt:  apiToken.CreatedAt.Unix(),
+			TokenType: "access_token",
+		}
+		if apiToken.User != nil {
+			res.Username = apiToken.User.Username
+		}
+		if apiToken.ExpiresAt != nil {
+			res.ExpiresAt = apiToken.ExpiresAt.Unix()
+		}
+		return res, nil
+	}
+
+	var refreshToken models.OAuthRefreshToken
+	if err := lib.DB.Where(models.OAuthRefreshToken{
+		BaseModel: models.BaseModel{ID: lib.HashToken(token)},
+		ClientID:  client.ID,
+	}).Preload("User").First(&refreshToken).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return inactive, nil
+		}
+		return nil, err
+	}
+
+	if refreshToken.ExpiresAt.Before(time.Now()) {
+		return inactive, nil
+	}
+
+	res := &IntrospectResponse{
+		Active:    true,
+		Scope:     strings.Join(refreshToken.Scopes, " "),
+		ClientID:  client.ID,
+		Subject:   refreshToken.UserID,
+		ExpiresAt: refreshToken.ExpiresAt.Unix(),
+		IssuedAt:  refreshToken.CreatedAt.Unix(),
+		TokenType: "refresh_token",
+	}
+	if refreshToken.User != nil {
+		res.Username = refreshToken.User.Username
+	}
+	return res, nil
+}
@@ -0,0 +1,104 @@
+package posts
+
+import (
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/models"
+)
+
+// populatePostCounts populates the counts and liked fields on the posts, along with their parents and any loaded replies.
+// The counts are aggregated by the database, so the number of queries stays the same however much engagement the posts have.
+func populatePostCounts(posts []models.Post, userID string) error {
+	byID := make(map[string][]*models.Post)
+
+	var collect func(post *models.Post)
+	collect = func(post *models.Post) {
+		replaceImageURLsWithProxy(post.Content)
+		byID[post.ID] = append(byID[post.ID], post)
+
+		if post.Parent != nil {
+			collect(post.Parent)
+		}
+		for i := range post.Posts {
+			collect(&post.Posts[i])
+		}
+	}
+
+	for i := range posts {
+		collect(&posts[i])
+	}
+
+	if len(byID) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(byID))
+	for id := range byID {
+		ids = append(ids, id)
+	}
+
+	var likes []struct {
+		PostID string
+		Count  int
+	}
+	if err := lib.DB.
+		Model(&models.Like{}).
+		Select("post_id, COUNT(*) AS count").
+		Where("post_id IN ?", ids).
+		Group("post_id").
+		Scan(&likes).Error; err != nil {
+		return err
+	}
+
+	for _, like := range likes {
+		for _, post := range byID[like.PostID] {
+			post.Counts.Likes = like.Count
+		}
+	}
+
+	var children []struct {
+		ParentID string
+		Type     models.PostType
+		Count    int
+	}
+	if err := lib.DB.
+		Model(&models.Post{}).
+		Select("parent_id, type, COUNT(*) AS count").
+		Where("parent_id IN ?", ids).
+		Where("type = ? OR type = ?", models.PostTypeReply, models.PostTypeRepost).
+		Group("parent_id, type").
+		Scan(&children).Error; err != nil {
+		return err
+	}
+
+	for _, child := range children {
+		for _, post := range byID[child.ParentID] {
+			switch child.Type {
+			case models.PostTypeReply:
+				post.Counts.Replies = child.Count
+			case models.PostTypeRepost:
+				post.Counts.Reposts = child.Count
+			}
+		}
+	}
+
+	// nothing can have been liked without a session
+	if userID == "" {
+		return nil
+	}
+
+	var liked []string
+	if err := lib.DB.
+		Model(&models.Like{}).
+		Where("user_id = ? AND post_id IN ?", userID, ids).
+		Pluck("post_id", &liked).Error; err != nil {
+		return err
+	}
+
+	for _, id := range liked {
+		for _, post := range byID[id] {
+			post.Liked = true
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package posts
+
+import (
+	"github.com/twibber/api/models"
+	"gorm.io/gorm"
+	"strconv"
+	"testing"
+)
+
+// countStatements counts the statements run through the database while it is counting.
+// The callbacks are registered on the connection, so it should not outlive the test.
+func countStatements(t *testing.T, db *gorm.DB) (start func(), count func() int) {
+	t.Helper()
+
+	counting, statements := false, 0
+	increment := func(*gorm.DB) {
+		if counting {
+			statements++
+		}
+	}
+
+	const name = "test:count_statements"
+	if err := db.Callback().Query().Before("gorm:query").Register(name, increment); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Callback().Row().Before("gorm:row").Register(name, increment); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Callback().Raw().Before("gorm:raw").Register(name, increment); err != nil {
+		t.Fatal(err)
+	}
+
+	start = func() {
+		counting, statements = true, 0
+	}
+	count = func() int {
+		counting = false
+		return statements
+	}
+	return start, count
+}
+
+// TestPopulatePostCountsStatements checks the number of statements does not grow with the number of likes.
+func TestPopulatePostCountsStatements(t *testing.T) {
+	db := testDB(t)
+	start, count := countStatements(t, db)
+
+	// One statement each for likes, replies and reposts, and whether the user liked the posts.
+	const want = 3
+
+	for _, likes := range []int{1, 100, 10000} {
+		t.Run(strconv.Itoa(likes)+" likes", func(t *testing.T) {
+			users := seedUsers(t, db, likes+1)
+			author, likers := users[0], users[1:]
+			posts := seedPosts(t, db, []models.User{author}, 1)
+
+			rows := make([]models.Like, len(likers))
+			for i, liker := range likers {
+				rows[i] = models.Like{UserID: liker.ID, PostID: posts[0].ID}
+			}
+			if err := db.CreateInBatches(&rows, seedBatch).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			start()
+			if err := populatePostCounts(posts, likers[0].ID); err != nil {
+				t.Fatal(err)
+			}
+			if statements := count(); statements != want {
+				t.Fatalf("ran %d statements, want %d", statements, want)
+			}
+
+			if posts[0].Counts.Likes != likes {
+				t.Fatalf("counted %d likes, want %d", posts[0].Counts.Likes, likes)
+			}
+			if !posts[0].Liked {
+				t.Fatal("post is not liked by the user who liked it")
+			}
+		})
+	}
+}
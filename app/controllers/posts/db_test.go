@@ -0,0 +1,127 @@
+package posts
+
+import (
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"os"
+	"testing"
+	"time"
+)
+
+// seedBatch is the number of rows inserted per statement when seeding.
+const seedBatch = 1000
+
+// testDB connects to the database given by TEST_DATABASE_DSN and migrates it, skipping the test when none is given.
+// The connection is used as lib.DB for the duration of the test.
+func testDB(tb testing.TB) *gorm.DB {
+	tb.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		tb.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	conn, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	db := lib.DB
+	lib.DB = conn
+	tb.Cleanup(func() {
+		lib.DB = db
+	})
+
+	lib.MigrateDB()
+
+	return conn
+}
+
+// seedUsers creates the number of users, which are deleted along with everything of theirs when the test ends.
+// Without a database the users are only made up, for tests which only need their IDs.
+func seedUsers(tb testing.TB, db *gorm.DB, n int) []models.User {
+	tb.Helper()
+
+	users := make([]models.User, n)
+	for i := range users {
+		id := utils.UUIDv4()
+		users[i] = models.User{
+			BaseModel: models.BaseModel{ID: id},
+			Username:  "seed-" + id,
+			Email:     id + "@twibber.test",
+		}
+	}
+
+	if db == nil {
+		return users
+	}
+
+	if err := db.CreateInBatches(&users, seedBatch).Error; err != nil {
+		tb.Fatal(err)
+	}
+
+	tb.Cleanup(func() {
+		ids := make([]string, len(users))
+		for i, user := range users {
+			ids[i] = user.ID
+		}
+		if err := db.Where("id IN ?", ids).Delete(&models.User{}).Error; err != nil {
+			tb.Error(err)
+		}
+	})
+
+	return users
+}
+
+// seedPosts creates the number of posts by each user, a minute apart and ending now.
+// Like seedUsers, without a database the posts are only made up.
+func seedPosts(tb testing.TB, db *gorm.DB, users []models.User, each int) []models.Post {
+	tb.Helper()
+
+	now := time.Now().Truncate(time.Microsecond)
+	posts := make([]models.Post, 0, len(users)*each)
+	for i := 0; i < each; i++ {
+		for _, user := range users {
+			id := utils.UUIDv4()
+			content := "Seeded post"
+			createdAt := now.Add(-time.Duration(len(posts)) * time.Minute)
+
+			posts = append(posts, models.Post{
+				BaseModel:      models.BaseModel{ID: id, Timestamps: models.Timestamps{CreatedAt: createdAt, UpdatedAt: createdAt}},
+				UserID:         user.ID,
+				ConversationID: id,
+				Type:           models.PostTypePost,
+				Content:        &content,
+			})
+		}
+	}
+
+	if db == nil {
+		return posts
+	}
+
+	// Hooks would overwrite the times the posts were made.
+	if err := db.Session(&gorm.Session{SkipHooks: true}).CreateInBatches(&posts, seedBatch).Error; err != nil {
+		tb.Fatal(err)
+	}
+
+	return posts
+}
+
+// seedFollows makes the user follow each of the others.
+func seedFollows(tb testing.TB, db *gorm.DB, user models.User, followed []models.User) {
+	tb.Helper()
+
+	follows := make([]models.Follow, len(followed))
+	for i, other := range followed {
+		follows[i] = models.Follow{UserID: user.ID, FollowedID: other.ID}
+	}
+
+	if err := db.CreateInBatches(&follows, seedBatch).Error; err != nil {
+		tb.Fatal(err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/twibber/api/img"
 	"github.com/twibber/api/lib"
 	"github.com/twibber/api/models"
+	"gorm.io/gorm"
 	"regexp"
 	"strings"
 )
@@ -18,30 +19,34 @@ func ListPosts(c *fiber.Ctx) error {
 		userID = session.Connection.User.ID
 	}
 
+	params, err := lib.ParseCursorParams(c)
+	if err != nil {
+		return err
+	}
+
 	var posts []models.Post
-	if err := lib.DB.
+	if err := params.Apply(lib.DB.
 		Model(&models.Post{}).
 		Preload("User").
-		Preload("Likes").
-		Preload("Posts").
 		Preload("Parent").
 		Preload("Parent.User").
-		Preload("Parent.Likes").
-		Preload("Parent.Posts").
 		Preload("Parent.Parent").
 		Where("type = ? OR type = ?", models.PostTypePost, models.PostTypeRepost).
-		Order("created_at desc").
+		Scopes(hideSuspended), "posts").
 		Find(&posts).Error; err != nil {
 		return err
 	}
 
-	for i := range posts {
-		populatePostCounts(&posts[i], userID, false)
+	posts, pagination := lib.CursorPage(params, posts, postCursor)
+
+	if err := populatePostCounts(posts, userID); err != nil {
+		return err
 	}
 
 	return c.Status(fiber.StatusOK).JSON(lib.Response{
-		Success: true,
-		Data:    posts,
+		Success:    true,
+		Data:       posts,
+		Pagination: pagination,
 	})
 }
 
@@ -56,69 +61,82 @@ func GetPostsByUser(c *fiber.Ctx) error {
 		return err
 	}
 
+	// the posts of suspended users are hidden along with their profile
+	if lib.IsSuspended(&user) {
+		return lib.ErrNotFound
+	}
+
+	sessionUserID := ""
+	if session := lib.GetSession(c); session != nil {
+		sessionUserID = session.Connection.User.ID
+	}
+
+	params, err := lib.ParseCursorParams(c)
+	if err != nil {
+		return err
+	}
+
 	var posts []models.Post
-	if err := lib.DB.
+	if err := params.Apply(lib.DB.
 		Model(&models.Post{}).
 		Preload("User").
-		Preload("Likes").
-		Preload("Posts").
 		Preload("Parent").
 		Preload("Parent.User").
-		Preload("Parent.Likes").
-		Preload("Parent.Posts").
 		Preload("Parent.Parent").
 		Where(&models.Post{
 			UserID: user.ID,
 		}).
 		Where("type = ? OR type = ?", models.PostTypePost, models.PostTypeRepost).
-		Order("created_at desc").
+		Scopes(visibleTo(sessionUserID)), "posts").
 		Find(&posts).Error; err != nil {
 		return err
 	}
 
-	sessionUserID := ""
-	if session := lib.GetSession(c); session != nil {
-		sessionUserID = session.Connection.User.ID
-	}
+	posts, pagination := lib.CursorPage(params, posts, postCursor)
 
-	for i := range posts {
-		populatePostCounts(&posts[i], sessionUserID, false)
+	if err := populatePostCounts(posts, sessionUserID); err != nil {
+		return err
 	}
 
 	return c.Status(fiber.StatusOK).JSON(lib.Response{
-		Success: true,
-		Data:    posts,
+		Success:    true,
+		Data:       posts,
+		Pagination: pagination,
 	})
 }
 
 func GetPost(c *fiber.Ctx) error {
 	postID := c.Params("post")
 
+	sessionUserID := ""
+	if session := lib.GetSession(c); session != nil {
+		sessionUserID = session.Connection.User.ID
+	}
+
+	// posts by suspended users, or users hidden from the session user, are not found
 	var post models.Post
 	if err := lib.DB.
 		Model(&models.Post{}).
 		Preload("User").
-		Preload("Likes").
-		Preload("Posts").
 		Preload("Parent").
 		Preload("Parent.User").
-		Preload("Parent.Likes").
-		Preload("Parent.Posts").
 		Preload("Parent.Parent").
+		Preload("Posts", func(db *gorm.DB) *gorm.DB {
+			return db.Where("type = ?", models.PostTypeReply).Scopes(visibleTo(sessionUserID))
+		}).
 		Preload("Posts.User").
-		Preload("Posts.Likes").
-		Preload("Posts.Posts").
-		Where("id = ?", postID).
+		Where("posts.id = ?", postID).
+		Scopes(visibleTo(sessionUserID)).
 		First(&post).Error; err != nil {
 		return err
 	}
 
-	sessionUserID := ""
-	if session := lib.GetSession(c); session != nil {
-		sessionUserID = session.Connection.User.ID
+	// the replies are returned with the post, so their counts are needed too
+	posts := []models.Post{post}
+	if err := populatePostCounts(posts, sessionUserID); err != nil {
+		return err
 	}
-
-	populatePostCounts(&post, sessionUserID, true)
+	post = posts[0]
 
 	return c.Status(fiber.StatusOK).JSON(lib.Response{
 		Success: true,
@@ -126,50 +144,32 @@ func GetPost(c *fiber.Ctx) error {
 	})
 }
 
-// populatePostCounts populates the counts and liked fields on a post.
-func populatePostCounts(post *models.Post, userID string, includeReplies bool) {
-	replaceImageURLsWithProxy(post.Content)
+// postCursor returns the position of the post in a list.
+func postCursor(post models.Post) lib.Cursor {
+	return lib.Cursor{CreatedAt: post.CreatedAt, ID: post.ID}
+}
 
-	// check if liked by user
-	for _, like := range post.Likes {
-		if like.UserID == userID {
-			post.Liked = true
-			break
-		}
+// checkBlocked rejects the session user acting on the post when either they or its author has blocked the other.
+func checkBlocked(userID string, post models.Post, action string) error {
+	blocked, err := lib.IsBlocked(lib.DB, userID, post.UserID)
+	if err != nil {
+		return err
 	}
 
-	// count likes on post
-	post.Counts.Likes = len(post.Likes)
-
-	var replies []models.Post
-	// count replies and reposts on post
-	for _, subPost := range post.Posts {
-		replaceImageURLsWithProxy(subPost.Content)
-
-		switch subPost.Type {
-		case models.PostTypeReply:
-			post.Counts.Replies++
-			if includeReplies {
-				populatePostCounts(&subPost, userID, false)
-				replaceImageURLsWithProxy(subPost.Content)
-				replies = append(replies, subPost)
-			}
-		case models.PostTypeRepost:
-			post.Counts.Reposts++
-		}
+	if blocked {
+		return lib.NewError(fiber.StatusForbidden, "You cannot "+action+" this post.", nil, "BLOCKED")
 	}
 
-	if includeReplies {
-		post.Posts = replies
-	} else {
-		post.Posts = nil
-	}
+	return nil
+}
 
-	// if there is a parent post, recursively populate its counts
-	if post.Parent != nil {
-		replaceImageURLsWithProxy(post.Parent.Content)
-		populatePostCounts(post.Parent, userID, false)
-	}
+// hideSuspended excludes posts by suspended users, along with reposts of their posts.
+func hideSuspended(db *gorm.DB) *gorm.DB {
+	suspended := lib.SuspendedUsers(lib.DB)
+
+	return db.
+		Where("posts.user_id NOT IN (?)", suspended).
+		Where("posts.parent_id IS NULL OR posts.parent_id NOT IN (?)", lib.DB.Model(&models.Post{}).Select("id").Where("user_id IN (?)", suspended))
 }
 
 func replaceImageURLsWithProxy(content *string) {
@@ -16,6 +16,10 @@ func LikePost(c *fiber.Ctx) error {
 		return err
 	}
 
+	if err := checkBlocked(session.Connection.User.ID, post, "like"); err != nil {
+		return err
+	}
+
 	// check if like exists
 	var like models.Like
 	if err := lib.DB.Where(&models.Like{
@@ -2,6 +2,7 @@ package posts
 
 import (
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
 	"github.com/twibber/api/lib"
 	"github.com/twibber/api/models"
 	"time"
@@ -19,14 +20,21 @@ func CreatePost(c *fiber.Ctx) error {
 		return err
 	}
 
-	if err := lib.DB.Create(&models.Post{
-		UserID:  session.Connection.User.ID,
-		Type:    models.PostTypePost,
-		Content: &post.Content,
-	}).Error; err != nil {
+	// a post starts its own conversation
+	id := utils.UUIDv4()
+	dbPost := models.Post{
+		BaseModel:      models.BaseModel{ID: id},
+		ConversationID: id,
+		UserID:         session.Connection.User.ID,
+		Type:           models.PostTypePost,
+		Content:        &post.Content,
+	}
+	if err := lib.DB.Create(&dbPost).Error; err != nil {
 		return err
 	}
 
+	lib.FanOutPost(dbPost)
+
 	return c.Status(fiber.StatusCreated).JSON(lib.Response{
 		Success: true,
 		Data:    post,
@@ -36,13 +44,18 @@ func CreatePost(c *fiber.Ctx) error {
 func DeletePost(c *fiber.Ctx) error {
 	session := c.Locals("session").(models.Session)
 
+	deleteAny, err := lib.HasPermission(lib.DB, session.Connection.UserID, lib.PermPostsDeleteAny)
+	if err != nil {
+		return err
+	}
+
 	var selector = &models.Post{
 		BaseModel: models.BaseModel{ID: c.Params("post")},
 		UserID:    session.Connection.User.ID,
 	}
 
-	// allow admins to delete any post
-	if session.Connection.User.Admin {
+	// allow moderators to delete any post
+	if deleteAny {
 		selector.UserID = ""
 	}
 
@@ -51,8 +64,8 @@ func DeletePost(c *fiber.Ctx) error {
 		return err
 	}
 
-	// allow deletion of posts within 5 minutes and allow admins to delete posts at any time
-	if !session.Connection.User.Admin && time.Since(post.CreatedAt) > time.Minute*5 {
+	// allow deletion of posts within 5 minutes and allow moderators to delete posts at any time
+	if !deleteAny && time.Since(post.CreatedAt) > time.Minute*5 {
 		return lib.NewError(fiber.StatusBadRequest, "You cannot delete a post after more than 5 minutes.", nil)
 	}
 
@@ -60,5 +73,7 @@ func DeletePost(c *fiber.Ctx) error {
 		return err
 	}
 
+	lib.RemoveFromTimelines(post)
+
 	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
 }
@@ -25,11 +25,16 @@ func CreateReply(c *fiber.Ctx) error {
 		return err
 	}
 
+	if err := checkBlocked(session.Connection.User.ID, parentPost, "reply to"); err != nil {
+		return err
+	}
+
 	dbReply := &models.Post{
-		UserID:   session.Connection.User.ID,
-		Type:     models.PostTypeReply,
-		ParentID: &parentPost.ID,
-		Content:  &dto.Content,
+		ConversationID: parentPost.ConversationID,
+		UserID:         session.Connection.User.ID,
+		Type:           models.PostTypeReply,
+		ParentID:       &parentPost.ID,
+		Content:        &dto.Content,
 	}
 
 	if err := lib.DB.Create(&dbReply).Error; err != nil {
@@ -2,6 +2,7 @@ package posts
 
 import (
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
 	"github.com/twibber/api/lib"
 	"github.com/twibber/api/models"
 )
@@ -29,17 +30,27 @@ func CreateRepost(c *fiber.Ctx) error {
 		return lib.NewError(fiber.StatusBadRequest, "You cannot repost a repost", nil)
 	}
 
+	if err := checkBlocked(session.Connection.User.ID, parentPost, "repost"); err != nil {
+		return err
+	}
+
+	// replies to a repost are a conversation of their own
+	id := utils.UUIDv4()
 	dbReply := &models.Post{
-		UserID:   session.Connection.User.ID,
-		Type:     models.PostTypeRepost,
-		ParentID: &parentPost.ID,
-		Content:  &dto.Content,
+		BaseModel:      models.BaseModel{ID: id},
+		ConversationID: id,
+		UserID:         session.Connection.User.ID,
+		Type:           models.PostTypeRepost,
+		ParentID:       &parentPost.ID,
+		Content:        &dto.Content,
 	}
 
 	if err := lib.DB.Create(&dbReply).Error; err != nil {
 		return err
 	}
 
+	lib.FanOutPost(*dbReply)
+
 	return c.Status(fiber.StatusCreated).JSON(lib.Response{
 		Success: true,
 		Data:    dbReply,
@@ -0,0 +1,297 @@
+package posts
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strconv"
+)
+
+// Limits on how many levels of replies are nested under the post of a thread.
+const (
+	threadDefaultDepth = 3
+	threadMaxDepth     = 10
+)
+
+// Limits on how many replies are nested under the replies of a thread, the rest are loaded from each reply's own thread.
+const (
+	threadChildLimit = 5   // Replies nested under each reply
+	threadMaxNested  = 200 // Replies nested in total, no deeper levels are loaded once reached
+)
+
+// Thread is a post along with the conversation around it.
+type Thread struct {
+	Ancestors []models.Post `json:"ancestors"` // The posts replied to on the way up from the post, the root first
+	Post      models.Post   `json:"post"`      // The post, with its replies nested under it
+	Muted     bool          `json:"muted"`     // Whether the session user has muted the conversation
+}
+
+// parseDepth parses how many levels of replies should be nested under the post.
+func parseDepth(c *fiber.Ctx) (int, error) {
+	raw := c.Query("depth")
+	if raw == "" {
+		return threadDefaultDepth, nil
+	}
+
+	depth, err := strconv.Atoi(raw)
+	if err != nil || depth < 1 || depth > threadMaxDepth {
+		return 0, lib.NewError(fiber.StatusBadRequest, "The depth must be between 1 and "+strconv.Itoa(threadMaxDepth)+".", &lib.ErrorDetails{
+			Fields: []lib.ErrorField{
+				{Name: "depth", Errors: []string{"The depth must be between 1 and " + strconv.Itoa(threadMaxDepth) + "."}},
+			},
+		})
+	}
+
+	return depth, nil
+}
+
+// visibleTo excludes posts which should not be shown to the user, who may be signed out.
+func visibleTo(userID string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		db = db.Scopes(hideSuspended)
+		if userID != "" {
+			db = db.Scopes(hideFrom(userID))
+		}
+		return db
+	}
+}
+
+// threadAncestors returns the chain of posts the post replies to, up to the post the conversation started at.
+func threadAncestors(post models.Post, userID string) ([]models.Post, error) {
+	ancestors := []models.Post{}
+	if post.Type != models.PostTypeReply || post.ParentID == nil {
+		return ancestors, nil
+	}
+
+	chain := lib.DB.Raw(`WITH RECURSIVE ancestors AS (
+			SELECT id, parent_id, type FROM posts WHERE id = ?
+			UNION ALL
+			SELECT posts.id, posts.parent_id, posts.type FROM posts
+			JOIN ancestors ON posts.id = ancestors.parent_id
+			WHERE ancestors.type = ?
+		)
+		SELECT id FROM ancestors`, *post.ParentID, models.PostTypeReply)
+
+	// posts are always older than their replies, so this puts the root first
+	if err := lib.DB.
+		Model(&models.Post{}).
+		Preload("User").
+		Where("posts.id IN (?)", chain).
+		Scopes(visibleTo(userID)).
+		Order("posts.created_at ASC").
+		Find(&ancestors).Error; err != nil {
+		return nil, err
+	}
+
+	return ancestors, nil
+}
+
+// threadReplies returns the page of replies to the post, with their own replies nested under them up to the depth.
+// Replies beyond the depth are left out, their parents' reply counts show where there are more,
+// as are those of parents left without any nested replies once threadMaxNested is reached.
+func threadReplies(postID, userID string, params *lib.CursorParams, depth int) ([]models.Post, *lib.Pagination, error) {
+	var replies []models.Post
+	if err := params.Apply(lib.DB.
+		Model(&models.Post{}).
+		Preload("User").
+		Where("posts.parent_id = ? AND posts.type = ?", postID, models.PostTypeReply).
+		Scopes(visibleTo(userID)), "posts").
+		Find(&replies).Error; err != nil {
+		return nil, nil, err
+	}
+
+	replies, pagination := lib.CursorPage(params, replies, postCursor)
+
+	// Each level is loaded under the visible replies of the one above, so replies whose parent is hidden are dropped with it.
+	parents := make([]*models.Post, len(replies))
+	for i := range replies {
+		parents[i] = &replies[i]
+	}
+
+	nested := 0
+	for level := 1; level < depth && len(parents) > 0 && nested < threadMaxNested; level++ {
+		children, err := nestReplies(parents, userID, threadMaxNested-nested)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		nested += len(children)
+		parents = children
+	}
+
+	return replies, pagination, nil
+}
+
+// nestReplies nests the most recent replies to each of the parents under them, ordered like the replies of a thread,
+// and returns the nested replies, no more than the budget. Parents with more replies than are nested get a cursor to the rest.
+func nestReplies(parents []*models.Post, userID string, budget int) ([]*models.Post, error) {
+	ids := make([]string, len(parents))
+	for i, parent := range parents {
+		ids[i] = parent.ID
+	}
+
+	// One more reply than is nested is ranked, to tell whether a parent has more.
+	ranked := lib.DB.Model(&models.Post{}).
+		Select(`posts.id, ROW_NUMBER() OVER (
+			PARTITION BY posts.parent_id
+			ORDER BY posts.created_at DESC, posts.id DESC
+		) AS position`).
+		Where("posts.parent_id IN ? AND posts.type = ?", ids, models.PostTypeReply).
+		Scopes(visibleTo(userID))
+
+	var replies []models.Post
+	if err := lib.DB.
+		Model(&models.Post{}).
+		Preload("User").
+		Where("posts.id IN (?)", lib.DB.Table("(?) AS ranked", ranked).Select("id").Where("position <= ?", threadChildLimit+1)).
+		Order("posts.created_at DESC").
+		Order("posts.id DESC").
+		Find(&replies).Error; err != nil {
+		return nil, err
+	}
+
+	byParent := make(map[string][]models.Post)
+	for _, reply := range replies {
+		byParent[*reply.ParentID] = append(byParent[*reply.ParentID], reply)
+	}
+
+	var nested []*models.Post
+	for _, parent := range parents {
+		limit := threadChildLimit
+		if left := budget - len(nested); left < limit {
+			limit = left
+		}
+
+		children := byParent[parent.ID]
+		if len(children) > limit {
+			children = children[:limit]
+			if len(children) > 0 {
+				parent.MoreReplies = postCursor(children[len(children)-1]).Encode()
+			}
+		}
+
+		parent.Posts = children
+		for i := range parent.Posts {
+			nested = append(nested, &parent.Posts[i])
+		}
+	}
+
+	return nested, nil
+}
+
+// GetThread returns a post along with the replies leading up to it and a page of the replies under it.
+func GetThread(c *fiber.Ctx) error {
+	userID := ""
+	if session := lib.GetSession(c); session != nil {
+		userID = session.Connection.User.ID
+	}
+
+	depth, err := parseDepth(c)
+	if err != nil {
+		return err
+	}
+
+	params, err := lib.ParseCursorParams(c)
+	if err != nil {
+		return err
+	}
+
+	// posts by suspended users, or users hidden from the session user, are not found
+	var post models.Post
+	if err := lib.DB.
+		Model(&models.Post{}).
+		Preload("User").
+		Where("posts.id = ?", c.Params("post")).
+		Scopes(visibleTo(userID)).
+		First(&post).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return lib.ErrNotFound
+		}
+		return err
+	}
+
+	ancestors, err := threadAncestors(post, userID)
+	if err != nil {
+		return err
+	}
+
+	replies, pagination, err := threadReplies(post.ID, userID, params, depth)
+	if err != nil {
+		return err
+	}
+	post.Posts = replies
+
+	// the counts of the whole thread are populated together
+	posts := append(ancestors, post)
+	if err := populatePostCounts(posts, userID); err != nil {
+		return err
+	}
+
+	thread := Thread{
+		Ancestors: posts[:len(posts)-1],
+		Post:      posts[len(posts)-1],
+	}
+
+	if userID != "" {
+		var muted int64
+		if err := lib.DB.Model(&models.ConversationMute{}).Where(&models.ConversationMute{
+			UserID:         userID,
+			ConversationID: post.ConversationID,
+		}).Count(&muted).Error; err != nil {
+			return err
+		}
+		thread.Muted = muted > 0
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.Response{
+		Success:    true,
+		Data:       thread,
+		Pagination: pagination,
+	})
+}
+
+// MuteConversation hides the conversation the post is in from the session user's timeline, muting it twice does nothing.
+func MuteConversation(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	var post models.Post
+	if err := lib.DB.Where("id = ?", c.Params("post")).First(&post).Error; err != nil {
+		return err
+	}
+
+	if err := lib.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.ConversationMute{
+		UserID:         session.Connection.User.ID,
+		ConversationID: post.ConversationID,
+	}).Error; err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+}
+
+// UnmuteConversation shows the conversation the post is in on the session user's timeline again.
+func UnmuteConversation(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	var post models.Post
+	if err := lib.DB.Where("id = ?", c.Params("post")).First(&post).Error; err != nil {
+		return err
+	}
+
+	result := lib.DB.Where(&models.ConversationMute{
+		UserID:         session.Connection.User.ID,
+		ConversationID: post.ConversationID,
+	}).Delete(&models.ConversationMute{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return lib.NewError(fiber.StatusBadRequest, "You have not muted this conversation.", nil)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+}
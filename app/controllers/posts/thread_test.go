@@ -0,0 +1,125 @@
+package posts
+
+import (
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/models"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+// seedReplies creates the number of replies to the post by the user, a second apart and after the post.
+func seedReplies(t *testing.T, db *gorm.DB, parent models.Post, user models.User, n int) []models.Post {
+	t.Helper()
+
+	replies := make([]models.Post, n)
+	for i := range replies {
+		createdAt := parent.CreatedAt.Add(time.Duration(i+1) * time.Second)
+		replies[i] = models.Post{
+			BaseModel:      models.BaseModel{ID: utils.UUIDv4(), Timestamps: models.Timestamps{CreatedAt: createdAt, UpdatedAt: createdAt}},
+			UserID:         user.ID,
+			ParentID:       &parent.ID,
+			ConversationID: parent.ConversationID,
+			Type:           models.PostTypeReply,
+		}
+	}
+
+	// Hooks would overwrite the times the replies were made.
+	if err := db.Session(&gorm.Session{SkipHooks: true}).Create(&replies).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return replies
+}
+
+func TestThreadRepliesLimitsChildren(t *testing.T) {
+	db := testDB(t)
+
+	users := seedUsers(t, db, 1)
+	root := seedPosts(t, db, users, 1)[0]
+	reply := seedReplies(t, db, root, users[0], 1)[0]
+	nested := seedReplies(t, db, reply, users[0], threadChildLimit+2)
+
+	replies, _, err := threadReplies(root.ID, "", &lib.CursorParams{Limit: lib.DefaultPageSize}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(replies) != 1 || len(replies[0].Posts) != threadChildLimit {
+		t.Fatalf("got %d replies, want 1 with %d nested", len(replies), threadChildLimit)
+	}
+	if replies[0].Posts[0].ID != nested[len(nested)-1].ID {
+		t.Fatal("the most recent nested reply is not first")
+	}
+	if replies[0].MoreReplies == "" {
+		t.Fatal("reply with more nested replies has no cursor to them")
+	}
+
+	// The cursor continues in the reply's own thread.
+	after, err := lib.DecodeCursor(replies[0].MoreReplies)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rest, _, err := threadReplies(reply.ID, "", &lib.CursorParams{Limit: lib.DefaultPageSize, After: after}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rest) != 2 || rest[0].ID != nested[1].ID || rest[1].ID != nested[0].ID {
+		t.Fatalf("got %d more replies, want the 2 oldest", len(rest))
+	}
+}
+
+func TestThreadRepliesLimitsNested(t *testing.T) {
+	db := testDB(t)
+
+	// One parent more than the nested replies of the others leave room for, each with fewer replies than the child limit.
+	const each = 3
+	users := seedUsers(t, db, 1)
+	root := seedPosts(t, db, users, 1)[0]
+	parents := seedReplies(t, db, root, users[0], threadMaxNested/each+1)
+	for _, parent := range parents {
+		seedReplies(t, db, parent, users[0], each)
+	}
+
+	replies, _, err := threadReplies(root.ID, "", &lib.CursorParams{Limit: lib.MaxPageSize}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nested := 0
+	for _, reply := range replies {
+		nested += len(reply.Posts)
+	}
+	if len(replies) != len(parents) || nested != threadMaxNested {
+		t.Fatalf("got %d replies with %d nested, want %d with %d", len(replies), nested, len(parents), threadMaxNested)
+	}
+
+	// The oldest parent is reached last, once the budget only leaves room for some of its replies.
+	if replies[0].MoreReplies != "" {
+		t.Fatal("reply with all of its replies nested has a cursor to more")
+	}
+	last := replies[len(replies)-1]
+	if len(last.Posts) != threadMaxNested%each || last.MoreReplies == "" {
+		t.Fatalf("last reply has %d nested and cursor %q, want %d and a cursor to the rest", len(last.Posts), last.MoreReplies, threadMaxNested%each)
+	}
+}
+
+func TestConversationMuteOutlivesRoot(t *testing.T) {
+	db := testDB(t)
+
+	users := seedUsers(t, db, 1)
+	root := seedPosts(t, db, users, 1)[0]
+	seedReplies(t, db, root, users[0], 1)
+
+	if err := db.Delete(&models.Post{}, "id = ?", root.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// Muting does not depend on the post at the root of the conversation still existing.
+	if err := db.Create(&models.ConversationMute{UserID: users[0].ID, ConversationID: root.ConversationID}).Error; err != nil {
+		t.Fatalf("muting a conversation whose root was deleted: %v", err)
+	}
+}
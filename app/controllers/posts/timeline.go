@@ -0,0 +1,150 @@
+package posts
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/models"
+	"gorm.io/gorm"
+)
+
+// hideFrom excludes posts by users hidden from the user, along with reposts of their posts.
+func hideFrom(userID string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		hidden := lib.HiddenUsers(lib.DB, userID)
+
+		return db.
+			Where("posts.user_id NOT IN (?)", hidden).
+			Where("posts.parent_id IS NULL OR posts.parent_id NOT IN (?)", lib.DB.Model(&models.Post{}).Select("id").Where("user_id IN (?)", hidden))
+	}
+}
+
+// hideMutedConversations excludes posts in conversations the user has muted, along with reposts of them.
+func hideMutedConversations(userID string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		muted := lib.MutedConversations(lib.DB, userID)
+
+		return db.
+			Where("posts.conversation_id NOT IN (?)", muted).
+			Where("posts.parent_id IS NULL OR posts.parent_id NOT IN (?)", lib.DB.Model(&models.Post{}).Select("id").Where("conversation_id IN (?)", muted))
+	}
+}
+
+// timelinePosts returns a query loading posts along with everything needed to show them on a timeline.
+func timelinePosts() *gorm.DB {
+	return lib.DB.
+		Model(&models.Post{}).
+		Preload("User").
+		Preload("Parent").
+		Preload("Parent.User").
+		Preload("Parent.Parent")
+}
+
+// homeTimeline returns a subquery selecting the IDs of the posts on the user's home timeline: posts and reposts
+// by the user and the users they follow, excluding suspended users, users hidden from them and conversations they have muted.
+// When an original has been reposted several times, or posted and reposted, only its most recent appearance is kept.
+func homeTimeline(userID string) *gorm.DB {
+	candidates := lib.DB.Model(&models.Post{}).
+		Select(`posts.id, ROW_NUMBER() OVER (
+			PARTITION BY CASE WHEN posts.type = ? THEN posts.parent_id ELSE posts.id END
+			ORDER BY posts.created_at DESC, posts.id DESC
+		) AS appearance`, models.PostTypeRepost).
+		Where("posts.user_id = ? OR posts.user_id IN (?)", userID, lib.FollowedUsers(lib.DB, userID)).
+		Where("posts.type = ? OR posts.type = ?", models.PostTypePost, models.PostTypeRepost).
+		Scopes(hideSuspended, hideFrom(userID), hideMutedConversations(userID))
+
+	return lib.DB.Table("(?) AS candidates", candidates).Select("id").Where("appearance = 1")
+}
+
+// queryHomeTimeline builds the page of the user's home timeline from the follow graph.
+func queryHomeTimeline(userID string, params *lib.CursorParams) ([]models.Post, *lib.Pagination, error) {
+	var posts []models.Post
+	if err := params.Apply(timelinePosts().
+		Where("posts.id IN (?)", homeTimeline(userID)), "posts").
+		Find(&posts).Error; err != nil {
+		return nil, nil, err
+	}
+
+	posts, pagination := lib.CursorPage(params, posts, postCursor)
+	return posts, pagination, nil
+}
+
+// storedHomeTimeline loads the page of the user's materialized home timeline.
+// The page is cut from the timeline itself, so hidden posts and those by unfollowed authors leave it short
+// rather than shifting the cursors.
+func storedHomeTimeline(userID string, params *lib.CursorParams) ([]models.Post, *lib.Pagination, error) {
+	items, err := lib.HomeTimeline(userID, params)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	items, pagination := lib.CursorPage(params, items, lib.TimelineItem.Cursor)
+
+	items, err = lib.RemoveUnfollowed(userID, items)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(items) == 0 {
+		return []models.Post{}, pagination, nil
+	}
+
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.PostID
+	}
+
+	var found []models.Post
+	if err := timelinePosts().
+		Where("posts.id IN ?", ids).
+		Scopes(hideSuspended, hideFrom(userID), hideMutedConversations(userID)).
+		Find(&found).Error; err != nil {
+		return nil, nil, err
+	}
+
+	// Posts are returned in the order of the timeline.
+	byID := make(map[string]models.Post, len(found))
+	for _, post := range found {
+		byID[post.ID] = post
+	}
+
+	posts := make([]models.Post, 0, len(found))
+	for _, id := range ids {
+		if post, ok := byID[id]; ok {
+			posts = append(posts, post)
+		}
+	}
+
+	return posts, pagination, nil
+}
+
+// HomeTimeline returns the posts and reposts of the users the session user follows, along with their own.
+func HomeTimeline(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+	userID := session.Connection.UserID
+
+	params, err := lib.ParseCursorParams(c)
+	if err != nil {
+		return err
+	}
+
+	// Timelines are built on every read when they are not materialized.
+	load := storedHomeTimeline
+	if lib.Timelines == nil {
+		load = queryHomeTimeline
+	}
+
+	posts, pagination, err := load(userID, params)
+	if err != nil {
+		return err
+	}
+
+	if err := populatePostCounts(posts, userID); err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.Response{
+		Success:    true,
+		Data:       posts,
+		Pagination: pagination,
+	})
+}
@@ -0,0 +1,251 @@
+package posts
+
+import (
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/models"
+	"gorm.io/gorm"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// timelineFixture is a reader following two authors, with a post by the first which the second has reposted.
+type timelineFixture struct {
+	reader, first, second   string
+	original, repost, later lib.TimelineItem
+}
+
+// newTimelineFixture creates the fixture's items, along with its users and posts when a database is given.
+func newTimelineFixture(t *testing.T, db *gorm.DB) timelineFixture {
+	t.Helper()
+
+	users := seedUsers(t, db, 3)
+	posts := seedPosts(t, db, users[1:2], 2)
+	later, original := posts[0], posts[1]
+
+	id := utils.UUIDv4()
+	createdAt := original.CreatedAt.Add(time.Second)
+	repost := models.Post{
+		BaseModel:      models.BaseModel{ID: id, Timestamps: models.Timestamps{CreatedAt: createdAt, UpdatedAt: createdAt}},
+		UserID:         users[2].ID,
+		ConversationID: id,
+		Type:           models.PostTypeRepost,
+		ParentID:       &original.ID,
+	}
+	if db != nil {
+		// Hooks would overwrite the time the repost was made.
+		if err := db.Session(&gorm.Session{SkipHooks: true}).Create(&repost).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return timelineFixture{
+		reader:   users[0].ID,
+		first:    users[1].ID,
+		second:   users[2].ID,
+		original: lib.NewTimelineItem(original),
+		repost:   lib.NewTimelineItem(repost),
+		later:    lib.NewTimelineItem(later),
+	}
+}
+
+// pageIDs returns the IDs of the posts on the first page of the timeline.
+func pageIDs(t *testing.T, store lib.TimelineStore, userID string) []string {
+	t.Helper()
+
+	items, err := store.Page(userID, &lib.CursorParams{Limit: lib.DefaultPageSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.PostID
+	}
+	return ids
+}
+
+func expectPage(t *testing.T, store lib.TimelineStore, userID string, want ...lib.TimelineItem) {
+	t.Helper()
+
+	got := pageIDs(t, store, userID)
+	if len(got) != len(want) {
+		t.Fatalf("got %d items, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i].PostID {
+			t.Fatalf("item %d is %s, want %s", i, got[i], want[i].PostID)
+		}
+	}
+}
+
+// testTimelineStore checks the behaviour every store must share.
+func testTimelineStore(t *testing.T, store lib.TimelineStore, db *gorm.DB) {
+	t.Run("shows the most recent appearance of an original", func(t *testing.T) {
+		f := newTimelineFixture(t, db)
+
+		if err := store.Add([]string{f.reader}, f.original, f.repost, f.later); err != nil {
+			t.Fatal(err)
+		}
+		// Delivering an item twice does nothing.
+		if err := store.Add([]string{f.reader}, f.repost); err != nil {
+			t.Fatal(err)
+		}
+
+		expectPage(t, store, f.reader, f.later, f.repost)
+	})
+
+	t.Run("keeps the original when its repost is removed", func(t *testing.T) {
+		f := newTimelineFixture(t, db)
+
+		if err := store.Add([]string{f.reader}, f.original, f.repost, f.later); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.RemovePost(f.repost.PostID); err != nil {
+			t.Fatal(err)
+		}
+
+		expectPage(t, store, f.reader, f.later, f.original)
+	})
+
+	t.Run("keeps the original when the reposter is removed", func(t *testing.T) {
+		f := newTimelineFixture(t, db)
+
+		if err := store.Add([]string{f.reader}, f.original, f.repost, f.later); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.RemoveAuthor(f.reader, f.second); err != nil {
+			t.Fatal(err)
+		}
+
+		expectPage(t, store, f.reader, f.later, f.original)
+	})
+
+	t.Run("removes reposts along with their original", func(t *testing.T) {
+		f := newTimelineFixture(t, db)
+
+		if err := store.Add([]string{f.reader}, f.original, f.repost, f.later); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.RemovePost(f.original.PostID); err != nil {
+			t.Fatal(err)
+		}
+
+		expectPage(t, store, f.reader, f.later)
+	})
+
+	t.Run("pages past deduplicated originals", func(t *testing.T) {
+		f := newTimelineFixture(t, db)
+
+		if err := store.Add([]string{f.reader}, f.original, f.repost, f.later); err != nil {
+			t.Fatal(err)
+		}
+
+		first, err := store.Page(f.reader, &lib.CursorParams{Limit: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(first) != 2 || first[0].PostID != f.later.PostID {
+			t.Fatalf("first page has %d items, want the later post and one more", len(first))
+		}
+
+		cursor := first[0].Cursor()
+		rest, err := store.Page(f.reader, &lib.CursorParams{Limit: lib.DefaultPageSize, After: &cursor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rest) != 1 || rest[0].PostID != f.repost.PostID {
+			t.Fatalf("next page has %d items, want only the repost", len(rest))
+		}
+
+		cursor = rest[0].Cursor()
+		newer, err := store.Page(f.reader, &lib.CursorParams{Limit: lib.DefaultPageSize, Before: &cursor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(newer) != 1 || newer[0].PostID != f.later.PostID {
+			t.Fatalf("previous page has %d items, want only the later post", len(newer))
+		}
+	})
+
+	t.Run("records built timelines", func(t *testing.T) {
+		f := newTimelineFixture(t, db)
+
+		if built, err := store.Built(f.reader); err != nil || built {
+			t.Fatalf("new timeline built = %v, %v", built, err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := store.MarkBuilt(f.reader); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if built, err := store.Built(f.reader); err != nil || !built {
+			t.Fatalf("marked timeline built = %v, %v", built, err)
+		}
+	})
+}
+
+func TestMemoryTimelineStore(t *testing.T) {
+	testTimelineStore(t, lib.NewMemoryTimelineStore(), nil)
+}
+
+func TestPostgresTimelineStore(t *testing.T) {
+	db := testDB(t)
+	testTimelineStore(t, lib.NewPostgresTimelineStore(db), db)
+}
+
+// BenchmarkHomeTimeline compares reading the first page of a home timeline built from the follow graph on every read
+// with reading it from each materialized store, for users following different numbers of authors.
+func BenchmarkHomeTimeline(b *testing.B) {
+	db := testDB(b)
+
+	timelines := lib.Timelines
+	b.Cleanup(func() {
+		lib.Timelines = timelines
+	})
+
+	for _, follows := range []int{100, 1000, 5000} {
+		users := seedUsers(b, db, follows+1)
+		reader, authors := users[0], users[1:]
+		seedFollows(b, db, reader, authors)
+		seedPosts(b, db, authors, 10)
+
+		stores := []struct {
+			name  string
+			store lib.TimelineStore
+		}{
+			{"none", nil},
+			{"memory", lib.NewMemoryTimelineStore()},
+			{"postgres", lib.NewPostgresTimelineStore(db)},
+		}
+
+		for _, store := range stores {
+			b.Run(store.name+"/follows="+strconv.Itoa(follows), func(b *testing.B) {
+				lib.Timelines = store.store
+
+				load := storedHomeTimeline
+				if store.store == nil {
+					load = queryHomeTimeline
+				}
+
+				// The first read builds the materialized timeline, which is not what is being measured.
+				params := &lib.CursorParams{Limit: lib.DefaultPageSize}
+				if _, _, err := load(reader.ID, params); err != nil {
+					b.Fatal(err)
+				}
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					posts, _, err := load(reader.ID, params)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if len(posts) != lib.DefaultPageSize {
+						b.Fatalf("got %d posts, want %d", len(posts), lib.DefaultPageSize)
+					}
+				}
+			})
+		}
+	}
+}
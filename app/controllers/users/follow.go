@@ -22,6 +22,15 @@ func FollowUser(c *fiber.Ctx) error {
 		return lib.NewError(fiber.StatusBadRequest, "You cannot follow yourself.", nil)
 	}
 
+	blocked, err := lib.IsBlocked(lib.DB, session.Connection.User.ID, user.ID)
+	if err != nil {
+		return err
+	}
+
+	if blocked {
+		return lib.NewError(fiber.StatusForbidden, "You cannot follow this user.", nil, "BLOCKED")
+	}
+
 	// ensure that the user does not already follow the user
 	var followExists int64
 	if err := lib.DB.Table("follows").Where(&models.Follow{
@@ -42,6 +51,8 @@ func FollowUser(c *fiber.Ctx) error {
 		return err
 	}
 
+	lib.BackfillTimeline(session.Connection.User.ID, user.ID)
+
 	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
 }
 
@@ -71,9 +82,16 @@ func UnfollowUser(c *fiber.Ctx) error {
 		return err
 	}
 
+	lib.PruneTimeline(session.Connection.User.ID, user.ID)
+
 	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
 }
 
+// followCursor returns the position of the follow in a list, so followers are listed by when they followed.
+func followCursor(follow models.Follow) lib.Cursor {
+	return lib.Cursor{CreatedAt: follow.CreatedAt, ID: follow.ID}
+}
+
 func GetFollowersByUsername(c *fiber.Ctx) error {
 	username := c.Params("user")
 	var user models.User
@@ -87,18 +105,25 @@ func GetFollowersByUsername(c *fiber.Ctx) error {
 		curUserID = session.Connection.User.ID
 	}
 
+	params, err := lib.ParseCursorParams(c)
+	if err != nil {
+		return err
+	}
+
 	var followers []models.Follow
-	if err := lib.DB.
+	if err := params.Apply(lib.DB.
 		Where(&models.Follow{
 			FollowedID: user.ID,
 		}).
 		Preload("User").
 		Preload("User.Followers").
-		Preload("User.Following").
+		Preload("User.Following"), "follows").
 		Find(&followers).Error; err != nil {
 		return err
 	}
 
+	followers, pagination := lib.CursorPage(params, followers, followCursor)
+
 	var followersUsers []models.User
 	for _, follower := range followers {
 		follower.User.YouFollow = false
@@ -122,8 +147,9 @@ func GetFollowersByUsername(c *fiber.Ctx) error {
 	}
 
 	return c.Status(fiber.StatusOK).JSON(lib.Response{
-		Success: true,
-		Data:    followersUsers,
+		Success:    true,
+		Data:       followersUsers,
+		Pagination: pagination,
 	})
 }
 
@@ -140,18 +166,25 @@ func GetFollowingByUsername(c *fiber.Ctx) error {
 		curUserID = session.Connection.User.ID
 	}
 
+	params, err := lib.ParseCursorParams(c)
+	if err != nil {
+		return err
+	}
+
 	var following []models.Follow
-	if err := lib.DB.
+	if err := params.Apply(lib.DB.
 		Where(&models.Follow{
 			UserID: user.ID,
 		}).
 		Preload("Followed").
 		Preload("Followed.Followers").
-		Preload("Followed.Following").
+		Preload("Followed.Following"), "follows").
 		Find(&following).Error; err != nil {
 		return err
 	}
 
+	following, pagination := lib.CursorPage(params, following, followCursor)
+
 	var followingUsers []models.User
 	for _, followed := range following {
 		followed.Followed.YouFollow = false
@@ -175,7 +208,8 @@ func GetFollowingByUsername(c *fiber.Ctx) error {
 	}
 
 	return c.Status(fiber.StatusOK).JSON(lib.Response{
-		Success: true,
-		Data:    followingUsers,
+		Success:    true,
+		Data:       followingUsers,
+		Pagination: pagination,
 	})
 }
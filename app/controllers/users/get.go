@@ -28,16 +28,25 @@ func ListUsers(c *fiber.Ctx) error {
 		userID = session.Connection.User.ID
 	}
 
+	params, err := lib.ParseCursorParams(c)
+	if err != nil {
+		return err
+	}
+
 	var dbUsers []models.User
-	if err := lib.DB.
+	if err := params.Apply(lib.DB.
 		Model(&models.User{}).
 		Preload("Followers").
 		Preload("Following").
-		Order("users.created_at DESC").
+		Where("users.id NOT IN (?)", lib.SuspendedUsers(lib.DB)), "users").
 		Find(&dbUsers).Error; err != nil {
 		return err
 	}
 
+	dbUsers, pagination := lib.CursorPage(params, dbUsers, func(user models.User) lib.Cursor {
+		return lib.Cursor{CreatedAt: user.CreatedAt, ID: user.ID}
+	})
+
 	// check if the user follows each user and if each user follows the user
 	for i, dbUser := range dbUsers {
 		for _, follower := range dbUser.Followers {
@@ -56,8 +65,9 @@ func ListUsers(c *fiber.Ctx) error {
 	}
 
 	return c.Status(fiber.StatusOK).JSON(lib.Response{
-		Success: true,
-		Data:    dbUsers,
+		Success:    true,
+		Data:       dbUsers,
+		Pagination: pagination,
 	})
 }
 
@@ -80,6 +90,11 @@ func GetUserByUsername(c *fiber.Ctx) error {
 		return err
 	}
 
+	// The profiles of suspended users are hidden
+	if lib.IsSuspended(&user) {
+		return lib.ErrNotFound
+	}
+
 	// Check if the user follows each user and if each user follows the user
 	for _, follower := range user.Followers {
 		if follower.UserID == curUserID {
@@ -0,0 +1,129 @@
+package users
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/models"
+	"gorm.io/gorm/clause"
+)
+
+// getOtherUser returns the user named in the route, who must not be the session user.
+func getOtherUser(c *fiber.Ctx, action string) (*models.User, error) {
+	session := c.Locals("session").(models.Session)
+
+	var user models.User
+	if err := lib.DB.Table("users").Where(models.User{
+		Username: c.Params("user"),
+	}).First(&user).Error; err != nil {
+		return nil, err
+	}
+
+	if user.ID == session.Connection.User.ID {
+		return nil, lib.NewError(fiber.StatusBadRequest, "You cannot "+action+" yourself.", nil)
+	}
+
+	return &user, nil
+}
+
+// MuteUser hides a user's posts from the session user's timeline, muting a user twice does nothing.
+func MuteUser(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	user, err := getOtherUser(c, "mute")
+	if err != nil {
+		return err
+	}
+
+	if err := lib.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.Mute{
+		UserID:  session.Connection.User.ID,
+		MutedID: user.ID,
+	}).Error; err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+}
+
+// UnmuteUser shows a muted user's posts on the session user's timeline again.
+func UnmuteUser(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	user, err := getOtherUser(c, "unmute")
+	if err != nil {
+		return err
+	}
+
+	result := lib.DB.Where(&models.Mute{
+		UserID:  session.Connection.User.ID,
+		MutedID: user.ID,
+	}).Delete(&models.Mute{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return lib.NewError(fiber.StatusBadRequest, "You have not muted this user.", nil)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+}
+
+// BlockUser blocks a user, removing any follows between the two users.
+func BlockUser(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	user, err := getOtherUser(c, "block")
+	if err != nil {
+		return err
+	}
+
+	tx := lib.DB.Begin()
+
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.Block{
+		UserID:    session.Connection.User.ID,
+		BlockedID: user.ID,
+	}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Where("(user_id = ? AND followed_id = ?) OR (user_id = ? AND followed_id = ?)",
+		session.Connection.User.ID, user.ID, user.ID, session.Connection.User.ID).
+		Delete(&models.Follow{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	lib.PruneTimeline(session.Connection.User.ID, user.ID)
+	lib.PruneTimeline(user.ID, session.Connection.User.ID)
+
+	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+}
+
+// UnblockUser removes the session user's block on a user, the follows it removed are not restored.
+func UnblockUser(c *fiber.Ctx) error {
+	session := c.Locals("session").(models.Session)
+
+	user, err := getOtherUser(c, "unblock")
+	if err != nil {
+		return err
+	}
+
+	result := lib.DB.Where(&models.Block{
+		UserID:    session.Connection.User.ID,
+		BlockedID: user.ID,
+	}).Delete(&models.Block{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return lib.NewError(fiber.StatusBadRequest, "You have not blocked this user.", nil)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(lib.BlankSuccess)
+}
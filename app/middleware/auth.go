@@ -1,26 +1,29 @@
 package middleware
 
 import (
-	"github.com/gofiber/fiber/v2" // Web framework for Golang
-	cfg "github.com/twibber/api/config"
+	"github.com/gofiber/fiber/v2"   // Web framework for Golang
 	"github.com/twibber/api/lib"    // Contains shared configurations and utilities
 	"github.com/twibber/api/models" // Data models for the application
 )
 
 // Auth middleware to handle authentication with optional verification.
-func Auth(verify bool) fiber.Handler {
+// API tokens are only accepted when the route declares scopes, and must have been granted all of them.
+func Auth(verify bool, scopes ...string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		session := lib.GetSession(c)
+		if lib.IsAPIToken(lib.GetAuthToken(c)) {
+			return tokenAuth(c, scopes)
+		}
 
-		if session == nil {
-			lib.ClearAuth(c)
-			return lib.ErrUnauthorised
+		session, err := lib.Authenticate(c)
+		if err != nil {
+			return err
 		}
 
 		// Proceeds if user is verified or verification isn't required
 		if !verify || session.Connection.Verified {
 			c.Locals("session", *session) // * as we don't need a pointer to the session
-			return c.Next()
+
+			return lib.AuditImpersonation(c, session, c.Next)
 		}
 
 		// Rejects unverified users for actions requiring verification
@@ -28,16 +31,63 @@ func Auth(verify bool) fiber.Handler {
 	}
 }
 
-// AdminCheck middleware restricts access to admin-only functionality.
-func AdminCheck(c *fiber.Ctx) error {
-	// Retrieves session from context
+// tokenAuth authenticates a request made with an API token.
+func tokenAuth(c *fiber.Ctx, scopes []string) error {
+	token := lib.GetAPIToken(c)
+	if token == nil {
+		return lib.ErrUnauthorised
+	}
+
+	if err := lib.CheckSuspended(token.User); err != nil {
+		return err
+	}
+
+	if len(scopes) == 0 || !lib.HasScopes(token.Scopes, scopes...) {
+		return lib.ErrInsufficientScope
+	}
+
+	// Handlers expect a session, so one is made up for the token's user.
+	// Tokens can only be created from verified accounts, so they count as verified.
+	c.Locals("session", models.Session{
+		Connection: &models.Connection{
+			UserID:   token.UserID,
+			User:     token.User,
+			Verified: true,
+		},
+	})
+	c.Locals("token", *token)
+
+	return c.Next()
+}
+
+// Require middleware restricts access to users holding the permission through one of their roles.
+// It must come after Auth.
+func Require(permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Retrieves session from context
+		session := c.Locals("session").(models.Session)
+
+		allowed, err := lib.HasPermission(lib.DB, session.Connection.UserID, permission)
+		if err != nil {
+			return err
+		}
+
+		if !allowed {
+			return lib.ErrMissingPermission
+		}
+
+		return c.Next()
+	}
+}
+
+// NoImpersonation middleware blocks sensitive actions, such as changing credentials, for staff impersonating a user.
+// It must come after Auth.
+func NoImpersonation(c *fiber.Ctx) error {
 	session := c.Locals("session").(models.Session)
 
-	// Blocks non-admin users unless in debug mode
-	if !session.Connection.User.Admin && !cfg.Config.Debug {
-		return lib.ErrForbidden
+	if session.ImpersonatorID != nil {
+		return lib.ErrImpersonating
 	}
 
-	// Allows request to proceed for admins or in debug mode
 	return c.Next()
 }
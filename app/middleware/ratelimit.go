@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v2"
+	log "github.com/sirupsen/logrus"
+	"github.com/twibber/api/lib"
+	"github.com/twibber/api/models"
+	"math"
+	"strconv"
+	"time"
+)
+
+// RateLimit middleware limits requests using the policy's token bucket.
+// Requests are counted per user when a session has been loaded by Auth beforehand, otherwise per IP address.
+func RateLimit(policy lib.RateLimitPolicy) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := "ip:" + c.IP()
+		if session, ok := c.Locals("session").(models.Session); ok {
+			key = "user:" + session.Connection.UserID
+		}
+
+		result, err := lib.TakeRateLimit(key, policy)
+		if err != nil {
+			// Requests are let through rather than failing the whole API when the store is unavailable.
+			log.WithError(err).WithField("policy", policy.Name).Warn("could not check rate limit")
+			return c.Next()
+		}
+
+		c.Set("RateLimit-Policy", policy.String())
+		c.Set("RateLimit-Limit", strconv.Itoa(policy.Limit))
+		c.Set("RateLimit-Remaining", strconv.Itoa(int(result.Remaining)))
+		c.Set("RateLimit-Reset", seconds(result.Reset(policy)))
+
+		if !result.Allowed {
+			return lib.ErrRateLimited.WithRetryAfter(result.RetryAfter(policy))
+		}
+
+		return c.Next()
+	}
+}
+
+// seconds formats a duration as whole seconds, rounded up.
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/sirupsen/logrus" // Logrus for structured logging
 	"os"                         // Standard library package for OS functionality
 	"reflect"                    // Standard library package for runtime reflection
+	"time"                       // Standard library package for durations
 )
 
 // Configuration struct to hold all environment variables.
@@ -13,6 +14,28 @@ type Configuration struct {
 	Debug bool   `env:"DEBUG"` // Debug mode toggle
 	Port  string `env:"PORT"`  // Application port
 
+	// Key used to sign values handed to clients, such as login links
+	SecretKey string `env:"SECRET_KEY"`
+
+	// Session lifetimes, sessions expire after the idle lifetime without activity and never outlive the absolute lifetime
+	SessionIdleLifetime             time.Duration `env:"SESSION_IDLE_LIFETIME"              default:"24h"`
+	SessionAbsoluteLifetime         time.Duration `env:"SESSION_ABSOLUTE_LIFETIME"          default:"168h"`
+	SessionRememberIdleLifetime     time.Duration `env:"SESSION_REMEMBER_IDLE_LIFETIME"     default:"720h"`  // Idle lifetime when "remember me" is ticked
+	SessionRememberAbsoluteLifetime time.Duration `env:"SESSION_REMEMBER_ABSOLUTE_LIFETIME" default:"2160h"` // Absolute lifetime when "remember me" is ticked
+	SessionRenewInterval            time.Duration `env:"SESSION_RENEW_INTERVAL"             default:"5m"`    // Minimum time between renewals of an active session
+
+	// Lifetime of the sessions staff are given when impersonating a user, they are never extended
+	ImpersonationLifetime time.Duration `env:"IMPERSONATION_LIFETIME" default:"30m"`
+
+	// Where failed login attempts are counted, "postgres" to share them between instances or "memory"
+	AttemptStore string `env:"ATTEMPT_STORE" default:"postgres"`
+
+	// Where rate limit buckets are kept, "memory" for a single instance or "postgres" to share them between instances
+	RateLimitStore string `env:"RATE_LIMIT_STORE" default:"memory"`
+
+	// Where home timelines are materialized, "postgres", "memory" for a single instance or "none" to build them on every read
+	TimelineStore string `env:"TIMELINE_STORE" default:"postgres"`
+
 	// URLs for various services
 	Domain    string `env:"DOMAIN"`     // Domain of the application
 	APIURL    string `env:"API_URL"`    // API endpoint URL
@@ -39,14 +62,30 @@ type Configuration struct {
 	MailSender   string `env:"MAIL_SENDER"`        // Email sender address
 	MailReply    string `env:"MAIL_REPLY"`         // Email reply-to address
 
-	// reCAPTCHA keys
-	CaptchaPublic string `env:"CAPTCHA_PUBLIC"` // Public key for reCAPTCHA
-	CaptchaSecret string `env:"CAPTCHA_SECRET"` // Secret key for reCAPTCHA
+	// Captcha provider and keys
+	CaptchaProvider string `env:"CAPTCHA_PROVIDER" default:"recaptcha"` // "recaptcha", "hcaptcha", "turnstile" or "none"
+	CaptchaPublic   string `env:"CAPTCHA_PUBLIC"`                       // Public site key of the provider
+	CaptchaSecret   string `env:"CAPTCHA_SECRET"`                       // Secret key of the provider
+	CaptchaHostname string `env:"CAPTCHA_HOSTNAME"`                     // Hostname captchas must be solved on, defaults to the public URL's host
 
 	// OAuth's providers' credentials
 	GoogleClient string `env:"GOOGLE_CLIENT_ID"`     // Google OAuth Client ID
 	GoogleSecret string `env:"GOOGLE_CLIENT_SECRET"` // Google OAuth Secret
 
+	// Google OAuth endpoints, overridable to test against a local provider
+	GoogleAuthURL     string `env:"GOOGLE_AUTH_URL"     default:"https://accounts.google.com/o/oauth2/v2/auth"`
+	GoogleTokenURL    string `env:"GOOGLE_TOKEN_URL"    default:"https://oauth2.googleapis.com/token"`
+	GoogleUserInfoURL string `env:"GOOGLE_USERINFO_URL" default:"https://openidconnect.googleapis.com/v1/userinfo"`
+
+	GitHubClient string `env:"GITHUB_CLIENT_ID"`     // GitHub OAuth Client ID
+	GitHubSecret string `env:"GITHUB_CLIENT_SECRET"` // GitHub OAuth Secret
+
+	// GitHub OAuth endpoints, overridable to test against a local provider
+	GitHubAuthURL     string `env:"GITHUB_AUTH_URL"     default:"https://github.com/login/oauth/authorize"`
+	GitHubTokenURL    string `env:"GITHUB_TOKEN_URL"    default:"https://github.com/login/oauth/access_token"`
+	GitHubUserInfoURL string `env:"GITHUB_USERINFO_URL" default:"https://api.github.com/user"`
+	GitHubEmailsURL   string `env:"GITHUB_EMAILS_URL"   default:"https://api.github.com/user/emails"`
+
 	DiscordClient  string `env:"DISCORD_CLIENT_ID"`     // Discord OAuth Client ID
 	DiscordSecret  string `env:"DISCORD_CLIENT_SECRET"` // Discord OAuth Secret
 	DiscordWebhook string `env:"DISCORD_WEBHOOK_URL"`   // Discord webhook URL
@@ -70,6 +109,7 @@ type Configuration struct {
 var Config = &Configuration{}
 
 // LoadConfiguration populates the Config struct with values from environment variables.
+// Fields with a "default" tag fall back to that value when the variable is unset.
 func LoadConfiguration(config *Configuration) {
 	val := reflect.ValueOf(config).Elem()
 
@@ -78,12 +118,24 @@ func LoadConfiguration(config *Configuration) {
 		typeField := val.Type().Field(i)
 		env := typeField.Tag.Get("env")
 
-		if typeField.Type.Kind() == reflect.Bool {
+		value, ok := os.LookupEnv(env)
+		if !ok || value == "" {
+			value = typeField.Tag.Get("default")
+		}
+
+		if typeField.Type == reflect.TypeOf(time.Duration(0)) {
+			// Parses and sets duration fields, such as "24h"
+			duration, err := time.ParseDuration(value)
+			if err != nil {
+				logrus.WithError(err).WithField("env", env).Fatal("invalid duration in configuration")
+			}
+			val.Field(i).SetInt(int64(duration))
+		} else if typeField.Type.Kind() == reflect.Bool {
 			// Parses and sets boolean fields
-			val.Field(i).SetBool(os.Getenv(env) == "true")
+			val.Field(i).SetBool(value == "true")
 		} else {
 			// Sets string fields
-			val.Field(i).SetString(os.Getenv(env))
+			val.Field(i).SetString(value)
 		}
 	}
 }
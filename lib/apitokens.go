@@ -0,0 +1,132 @@
+package lib
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/twibber/api/models"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// APITokenPrefix starts every API token, so they can be told apart from session tokens.
+const APITokenPrefix = "twb_"
+
+// apiTokenTouchInterval is the minimum time between updates of when a token was last used.
+const apiTokenTouchInterval = time.Minute
+
+// Scopes which can be granted to API tokens.
+const (
+	ScopePostsRead    = "posts:read"
+	ScopePostsWrite   = "posts:write"
+	ScopeLikesWrite   = "likes:write"
+	ScopeFollowsWrite = "follows:write"
+	ScopeAccountRead  = "account:read"
+)
+
+// Scopes lists every scope which can be granted to an API token.
+var Scopes = []string{ScopePostsRead, ScopePostsWrite, ScopeLikesWrite, ScopeFollowsWrite, ScopeAccountRead}
+
+// ErrInsufficientScope is returned when an API token is used on a route it has not been granted access to.
+var ErrInsufficientScope = NewError(fiber.StatusForbidden, "This token has not been granted access to this endpoint.", nil, "INSUFFICIENT_SCOPE")
+
+// IsAPIToken reports whether the auth token is an API token rather than a session token.
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
+
+// ValidScope reports whether the scope can be granted to an API token.
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScopes reports whether every one of the scopes has been granted.
+func HasScopes(granted []string, scopes ...string) bool {
+	for _, scope := range scopes {
+		found := false
+		for _, g := range granted {
+			if g == scope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// CreateAPIToken creates a personal API token for the user and returns it along with its record, only its hash is stored.
+func CreateAPIToken(tx *gorm.DB, userID, name string, scopes []string, expiresAt *time.Time) (string, *models.APIToken, error) {
+	apiToken := models.APIToken{
+		UserID:    userID,
+		Name:      name,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+
+	token, err := createAPIToken(tx, &apiToken)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, &apiToken, nil
+}
+
+// createAPIToken generates a token for the record and stores it.
+func createAPIToken(tx *gorm.DB, apiToken *models.APIToken) (string, error) {
+	token := APITokenPrefix + GenerateString(48)
+
+	apiToken.TokenHash = HashToken(token)
+	apiToken.Hint = token[:len(APITokenPrefix)+4]
+
+	if err := tx.Create(apiToken).Error; err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// GetAPIToken returns the API token provided in the request along with its user, or nil if it is unknown or expired.
+func GetAPIToken(c *fiber.Ctx) *models.APIToken {
+	authToken := GetAuthToken(c)
+	if !IsAPIToken(authToken) {
+		return nil
+	}
+
+	var apiToken models.APIToken
+	if err := DB.Where(models.APIToken{
+		TokenHash: HashToken(authToken),
+	}).Preload("User").First(&apiToken).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			logrus.Debug("API token not found")
+		}
+		return nil
+	}
+
+	now := time.Now()
+
+	if apiToken.ExpiresAt != nil && apiToken.ExpiresAt.Before(now) {
+		logrus.Debug("API token expired")
+		return nil
+	}
+
+	// Usage is recorded at most once per interval to avoid a write on every request.
+	if apiToken.LastUsedAt == nil || now.Sub(*apiToken.LastUsedAt) >= apiTokenTouchInterval {
+		if err := DB.Model(&apiToken).Updates(map[string]any{
+			"last_used_at": now,
+			"last_used_ip": c.IP(),
+		}).Error; err != nil {
+			logrus.WithError(err).Error("API token usage could not be recorded")
+		}
+	}
+
+	return &apiToken
+}
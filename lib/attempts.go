@@ -0,0 +1,348 @@
+package lib
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	log "github.com/sirupsen/logrus"
+	"github.com/twibber/api/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Attempts is the store used to count failed attempts, set once the database connection is established.
+var Attempts AttemptStore
+
+// Errors returned when attempts are being throttled, the retry delay is set with WithRetryAfter.
+var (
+	ErrTooManyAttempts = NewError(fiber.StatusTooManyRequests, "Too many attempts. Please wait before trying again.", nil, "TOO_MANY_ATTEMPTS")
+	ErrAccountLocked   = NewError(fiber.StatusLocked, "This account has been temporarily locked after too many failed attempts.", nil, "ACCOUNT_LOCKED")
+)
+
+// AttemptState is the failed attempt history of a key.
+type AttemptState struct {
+	Failures    int       // Consecutive failures within the window
+	LastFailure time.Time // Time of the most recent failure
+	LockedUntil time.Time // Time until which attempts are refused, zero if the key has not been locked
+}
+
+// AttemptStore keeps failed attempt counters, implementations must be safe for concurrent use.
+type AttemptStore interface {
+	// Get returns the state of the key, which is empty if no failures have been recorded.
+	Get(key string) (AttemptState, error)
+	// Increment records a failure, restarting the count if the previous failure is older than the window.
+	Increment(key string, window time.Duration) (AttemptState, error)
+	// Lock refuses attempts until the given time and clears the failure count.
+	Lock(key string, until time.Time) error
+	// Reset forgets every failure recorded for the key.
+	Reset(key string) error
+}
+
+// NewAttemptStore returns the store of the given kind, defaulting to Postgres.
+func NewAttemptStore(kind string) AttemptStore {
+	switch kind {
+	case "memory":
+		return NewMemoryAttemptStore()
+	case "", "postgres":
+		return NewPostgresAttemptStore(DB)
+	default:
+		log.WithField("store", kind).Fatal("unknown attempt store")
+		return nil
+	}
+}
+
+// AttemptPolicy describes how failures against a kind of key are throttled.
+// Once the free failures are used up, each failure doubles the delay before the next attempt, up to MaxDelay.
+type AttemptPolicy struct {
+	Prefix       string        // Prefix separating the keys of this policy from others
+	FreeFailures int           // Failures allowed before any delay applies
+	BaseDelay    time.Duration // Delay after the first failure past the free ones
+	MaxDelay     time.Duration // Upper bound of the delay
+	Window       time.Duration // Failures are forgotten after this long without another
+	LockAfter    int           // Failures after which the key is locked, zero to never lock
+	LockDuration time.Duration // How long a locked key refuses attempts
+	LockError    Error         // Error returned while the key is locked
+}
+
+// Predefined attempt policies for authentication endpoints.
+var (
+	LoginAccountPolicy = AttemptPolicy{
+		Prefix:       "login:account:",
+		FreeFailures: 3,
+		BaseDelay:    time.Second,
+		MaxDelay:     5 * time.Minute,
+		Window:       time.Hour,
+		LockAfter:    10,
+		LockDuration: 15 * time.Minute,
+		LockError:    ErrAccountLocked,
+	}
+	LoginIPPolicy = AttemptPolicy{
+		Prefix:       "login:ip:",
+		FreeFailures: 10,
+		BaseDelay:    time.Second,
+		MaxDelay:     15 * time.Minute,
+		Window:       time.Hour,
+		LockAfter:    100,
+		LockDuration: time.Hour,
+		LockError:    ErrTooManyAttempts,
+	}
+	// MFA failures are counted per user rather than per challenge, as anyone with the password can start new challenges.
+	MFAUserPolicy = AttemptPolicy{
+		Prefix:       "mfa:user:",
+		FreeFailures: 3,
+		BaseDelay:    time.Second,
+		MaxDelay:     5 * time.Minute,
+		Window:       time.Hour,
+		LockAfter:    10,
+		LockDuration: 15 * time.Minute,
+		LockError:    ErrAccountLocked,
+	}
+	ResendAccountPolicy = AttemptPolicy{
+		Prefix:       "resend:account:",
+		FreeFailures: 1,
+		BaseDelay:    30 * time.Second,
+		MaxDelay:     time.Hour,
+		Window:       24 * time.Hour,
+	}
+	ResendIPPolicy = AttemptPolicy{
+		Prefix:       "resend:ip:",
+		FreeFailures: 5,
+		BaseDelay:    30 * time.Second,
+		MaxDelay:     time.Hour,
+		Window:       24 * time.Hour,
+	}
+)
+
+// AccountKey normalises an email address for use as an attempt key.
+func AccountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Delay returns how long to wait after the given number of consecutive failures.
+func (p AttemptPolicy) Delay(failures int) time.Duration {
+	if failures <= p.FreeFailures {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeFailures + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+// Check returns an error carrying the retry delay if the key is locked or still backing off.
+func (p AttemptPolicy) Check(key string) error {
+	state, err := Attempts.Get(p.Prefix + key)
+	if err != nil {
+		return err
+	}
+
+	return p.check(state, time.Now())
+}
+
+func (p AttemptPolicy) check(state AttemptState, now time.Time) error {
+	if state.LockedUntil.After(now) {
+		return p.LockError.WithRetryAfter(state.LockedUntil.Sub(now))
+	}
+
+	if next := state.LastFailure.Add(p.Delay(state.Failures)); next.After(now) {
+		return ErrTooManyAttempts.WithRetryAfter(next.Sub(now))
+	}
+
+	return nil
+}
+
+// Record counts a failure against the key, locking it once the policy's limit is reached.
+// It reports whether this failure locked the key.
+func (p AttemptPolicy) Record(key string) (bool, error) {
+	state, err := Attempts.Increment(p.Prefix+key, p.Window)
+	if err != nil {
+		return false, err
+	}
+
+	if p.LockAfter == 0 || state.Failures < p.LockAfter {
+		return false, nil
+	}
+
+	return true, Attempts.Lock(p.Prefix+key, time.Now().Add(p.LockDuration))
+}
+
+// Reset forgets the failures recorded against the key, such as after a successful login.
+func (p AttemptPolicy) Reset(key string) error {
+	return Attempts.Reset(p.Prefix + key)
+}
+
+// MemoryAttemptStore keeps counters in memory, so they are lost on restart and not shared between instances.
+type MemoryAttemptStore struct {
+	mu        sync.Mutex
+	states    map[string]memoryAttempt
+	lastSweep time.Time
+}
+
+type memoryAttempt struct {
+	AttemptState
+	expiresAt time.Time // Time after which the entry no longer matters and can be dropped
+}
+
+// memorySweepInterval is how often expired counters are removed from memory.
+const memorySweepInterval = time.Minute
+
+// NewMemoryAttemptStore creates an empty in-memory store.
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{states: make(map[string]memoryAttempt)}
+}
+
+func (s *MemoryAttemptStore) Get(key string) (AttemptState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.states[key]
+	if !ok || entry.expiresAt.Before(time.Now()) {
+		return AttemptState{}, nil
+	}
+
+	return entry.AttemptState, nil
+}
+
+func (s *MemoryAttemptStore) Increment(key string, window time.Duration) (AttemptState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	entry := s.states[key]
+	if entry.LastFailure.Before(now.Add(-window)) {
+		entry.Failures = 0
+	}
+
+	entry.Failures++
+	entry.LastFailure = now
+	entry.expiresAt = later(now.Add(window), entry.LockedUntil)
+	s.states[key] = entry
+
+	return entry.AttemptState, nil
+}
+
+func (s *MemoryAttemptStore) Lock(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := s.states[key]
+	entry.Failures = 0
+	entry.LockedUntil = until
+	entry.expiresAt = later(entry.expiresAt, until)
+	s.states[key] = entry
+
+	return nil
+}
+
+func (s *MemoryAttemptStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.states, key)
+	return nil
+}
+
+// sweep drops expired entries so the map does not grow without bound, the lock must be held.
+func (s *MemoryAttemptStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < memorySweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, entry := range s.states {
+		if entry.expiresAt.Before(now) {
+			delete(s.states, key)
+		}
+	}
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// PostgresAttemptStore keeps counters in the database so they are shared between instances.
+type PostgresAttemptStore struct {
+	db *gorm.DB
+}
+
+// NewPostgresAttemptStore creates a store backed by the attempt_counters table.
+func NewPostgresAttemptStore(db *gorm.DB) *PostgresAttemptStore {
+	return &PostgresAttemptStore{db: db}
+}
+
+func (s *PostgresAttemptStore) Get(key string) (AttemptState, error) {
+	var counter models.AttemptCounter
+	if err := s.db.Where(models.AttemptCounter{
+		BaseModel: models.BaseModel{ID: key},
+	}).First(&counter).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return AttemptState{}, nil
+		}
+		return AttemptState{}, err
+	}
+
+	return counterState(counter), nil
+}
+
+func (s *PostgresAttemptStore) Increment(key string, window time.Duration) (AttemptState, error) {
+	now := time.Now()
+
+	// The count is restarted in the same statement when the last failure is outside the window,
+	// so concurrent failures are never lost.
+	counter := models.AttemptCounter{
+		BaseModel:     models.BaseModel{ID: key},
+		Failures:      1,
+		LastFailureAt: now,
+	}
+	if err := s.db.Clauses(
+		clause.OnConflict{
+			Columns: []clause.Column{{Name: "id"}},
+			DoUpdates: clause.Assignments(map[string]any{
+				"failures": gorm.Expr("CASE WHEN attempt_counters.last_failure_at < ? THEN 1 ELSE attempt_counters.failures + 1 END",
+					now.Add(-window)),
+				"last_failure_at": now,
+				"updated_at":      now,
+			}),
+		},
+		clause.Returning{},
+	).Create(&counter).Error; err != nil {
+		return AttemptState{}, err
+	}
+
+	return counterState(counter), nil
+}
+
+func counterState(counter models.AttemptCounter) AttemptState {
+	state := AttemptState{
+		Failures:    counter.Failures,
+		LastFailure: counter.LastFailureAt,
+	}
+	if counter.LockedUntil != nil {
+		state.LockedUntil = *counter.LockedUntil
+	}
+	return state
+}
+
+func (s *PostgresAttemptStore) Lock(key string, until time.Time) error {
+	return s.db.Model(&models.AttemptCounter{}).Where("id = ?", key).Updates(map[string]any{
+		"failures":     0,
+		"locked_until": until,
+	}).Error
+}
+
+func (s *PostgresAttemptStore) Reset(key string) error {
+	return s.db.Where("id = ?", key).Delete(&models.AttemptCounter{}).Error
+}
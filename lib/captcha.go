@@ -2,82 +2,213 @@ package lib
 
 import (
 	"encoding/json"
+	"errors"
+	"github.com/gofiber/fiber/v2"
 	log "github.com/sirupsen/logrus"
 	cfg "github.com/twibber/api/config"
-	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
-const siteVerifyURL = "https://www.google.com/recaptcha/api/siteverify"
+// Site verification endpoints of the supported captcha providers.
+const (
+	reCaptchaVerifyURL = "https://www.google.com/recaptcha/api/siteverify"
+	hCaptchaVerifyURL  = "https://api.hcaptcha.com/siteverify"
+	turnstileVerifyURL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+)
+
+// captchaClient is the HTTP client used to talk to captcha providers.
+var captchaClient = &http.Client{Timeout: 10 * time.Second}
+
+// Actions passed to CheckCaptcha, which must match the action given to the captcha widget.
+const (
+	CaptchaRegister  = "register"
+	CaptchaLogin     = "login"
+	CaptchaForgot    = "forgot_password"
+	CaptchaMagicLink = "magic_link"
+)
 
-// SiteVerifyResponse struct maps the JSON response from reCAPTCHA verification.
-type SiteVerifyResponse struct {
-	Success     bool      `json:"success"`      // Indicates if the captcha was successful
-	Score       float64   `json:"score"`        // Score for the captcha action
-	Action      string    `json:"action"`       // Action associated with the captcha
-	ChallengeTS time.Time `json:"challenge_ts"` // Timestamp of the captcha challenge
-	Hostname    string    `json:"hostname"`     // Hostname of the site where the captcha was solved
-	ErrorCodes  []string  `json:"error-codes"`  // Any error codes returned by the verification
+// DefaultCaptchaThreshold is the minimum score accepted for actions without their own threshold.
+const DefaultCaptchaThreshold = 0.3
+
+// CaptchaThresholds holds the minimum score accepted for each action, for providers which return a score.
+var CaptchaThresholds = map[string]float64{
+	CaptchaRegister: 0.5, // Account creation is the main target of bots
+	CaptchaLogin:    0.3,
+	CaptchaForgot:   0.3,
 }
 
-// CheckCaptcha verifies the reCAPTCHA response token.
-func CheckCaptcha(captcha string) error {
-	// Skip verification in development environment.
-	if cfg.Config.Debug {
-		return nil
+// Captcha is the verifier used by CheckCaptcha, set on startup by ConfigureCaptcha.
+// It may be replaced, such as with a FakeCaptchaVerifier in tests.
+var Captcha CaptchaVerifier
+
+// ConfigureCaptcha sets up the verifier of the configured provider, stopping the application if it cannot be.
+func ConfigureCaptcha() {
+	verifier, err := NewCaptchaVerifier(cfg.Config.CaptchaProvider, cfg.Config.CaptchaSecret)
+	if err != nil {
+		log.WithError(err).WithField("provider", cfg.Config.CaptchaProvider).Fatal("failed to configure captcha")
 	}
+	Captcha = verifier
+}
 
-	// Return error if captcha token is empty.
-	if captcha == "" {
-		log.Warn("reCAPTCHA verification failed: captcha token is empty")
-		return ErrInvalidCaptcha
+// CaptchaResult is the outcome of verifying a captcha token with its provider.
+type CaptchaResult struct {
+	Success    bool     `json:"success"`     // Indicates if the captcha was solved
+	Score      *float64 `json:"score"`       // Score given to the request, for providers which score them
+	Action     string   `json:"action"`      // Action the captcha was solved for, if the provider supports actions
+	Hostname   string   `json:"hostname"`    // Hostname of the site where the captcha was solved
+	ErrorCodes []string `json:"error-codes"` // Any error codes returned by the verification
+}
+
+// CaptchaVerifier verifies captcha tokens submitted by clients.
+type CaptchaVerifier interface {
+	Verify(token, remoteIP string) (*CaptchaResult, error)
+}
+
+// NewCaptchaVerifier returns the verifier for the given provider.
+// Verification is only disabled when the provider is "none", a provider without a secret is an error even in debug mode.
+func NewCaptchaVerifier(provider, secret string) (CaptchaVerifier, error) {
+	if provider == "none" {
+		return NoopCaptchaVerifier{}, nil
 	}
 
-	// Prepare a POST request to the Google reCAPTCHA API.
-	req, err := http.NewRequest(http.MethodPost, siteVerifyURL, nil)
-	if err != nil {
-		return err
+	var verifyURL string
+	switch provider {
+	case "", "recaptcha":
+		verifyURL = reCaptchaVerifyURL
+	case "hcaptcha":
+		verifyURL = hCaptchaVerifyURL
+	case "turnstile":
+		verifyURL = turnstileVerifyURL
+	default:
+		return nil, errors.New("unknown captcha provider " + strconv.Quote(provider))
+	}
+
+	if secret == "" {
+		return nil, errors.New(`CAPTCHA_SECRET must be set, or CAPTCHA_PROVIDER set to "none" to disable captchas`)
+	}
+
+	return &SiteVerifier{URL: verifyURL, Secret: secret}, nil
+}
+
+// SiteVerifier verifies tokens using a provider's siteverify endpoint.
+// reCAPTCHA v3, hCaptcha and Turnstile share the same request and response format.
+type SiteVerifier struct {
+	URL    string // Site verification endpoint of the provider
+	Secret string // Secret key issued by the provider
+}
+
+func (v *SiteVerifier) Verify(token, remoteIP string) (*CaptchaResult, error) {
+	if token == "" {
+		return &CaptchaResult{ErrorCodes: []string{"missing-input-response"}}, nil
 	}
 
-	// Set the necessary query parameters for the request.
-	q := req.URL.Query()
-	q.Add("secret", cfg.Config.CaptchaSecret)
-	q.Add("response", captcha)
-	req.URL.RawQuery = q.Encode()
+	form := url.Values{}
+	form.Set("secret", v.Secret)
+	form.Set("response", token)
+	form.Set("remoteip", remoteIP)
 
-	// Execute the HTTP request.
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := captchaClient.PostForm(v.URL, form)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer resp.Body.Close()
 
-	// Ensure response body is closed after function execution.
-	defer func(Body io.ReadCloser) {
-		if e := Body.Close(); e != nil {
-			panic(e) // Panic if closing the response body fails, might replace with logging
-		}
-	}(resp.Body)
+	var result CaptchaResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
 
-	// Parse the JSON response from the reCAPTCHA server.
-	var body SiteVerifyResponse
-	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+// NoopCaptchaVerifier accepts every token, used when verification is disabled.
+type NoopCaptchaVerifier struct{}
+
+func (NoopCaptchaVerifier) Verify(string, string) (*CaptchaResult, error) {
+	return &CaptchaResult{Success: true, Hostname: CaptchaHostname()}, nil
+}
+
+// FakeCaptchaVerifier returns a fixed result, so that failed verifications can be exercised without a provider.
+type FakeCaptchaVerifier struct {
+	Result CaptchaResult
+	Err    error
+}
+
+func (f *FakeCaptchaVerifier) Verify(string, string) (*CaptchaResult, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	result := f.Result
+	return &result, nil
+}
+
+// CheckCaptcha verifies the captcha token submitted with the request for the given action.
+// The token must have been solved on our hostname, for the same action, and score at least the action's threshold.
+func CheckCaptcha(c *fiber.Ctx, token, action string) error {
+	result, err := Captcha.Verify(token, c.IP())
+	if err != nil {
 		return err
 	}
 
-	// Validate if reCAPTCHA verification was successful and score is above the threshold.
-	if !body.Success || body.Score < 0.3 {
+	if reason := captchaFailure(result, action); reason != "" {
 		log.WithFields(log.Fields{
-			"success":      body.Success,
-			"score":        body.Score,
-			"action":       body.Action,
-			"challenge_ts": body.ChallengeTS,
-			"hostname":     body.Hostname,
-			"error_codes":  body.ErrorCodes,
-		}).Warn("reCAPTCHA verification failed")
+			"reason":      reason,
+			"success":     result.Success,
+			"score":       result.Score,
+			"action":      result.Action,
+			"hostname":    result.Hostname,
+			"error_codes": result.ErrorCodes,
+		}).Warn("captcha verification failed")
 
 		return ErrInvalidCaptcha
 	}
 
 	return nil
 }
+
+// captchaFailure returns why the result does not pass for the action, or an empty string if it does.
+func captchaFailure(result *CaptchaResult, action string) string {
+	if !result.Success {
+		return "unsuccessful"
+	}
+
+	if hostname := CaptchaHostname(); hostname != "" && !strings.EqualFold(result.Hostname, hostname) {
+		return "hostname"
+	}
+
+	// Providers without actions leave it empty.
+	if result.Action != "" && result.Action != action {
+		return "action"
+	}
+
+	if result.Score != nil {
+		threshold, ok := CaptchaThresholds[action]
+		if !ok {
+			threshold = DefaultCaptchaThreshold
+		}
+
+		if *result.Score < threshold {
+			return "score"
+		}
+	}
+
+	return ""
+}
+
+// CaptchaHostname returns the hostname captchas are expected to be solved on,
+// which defaults to the host of the public URL.
+func CaptchaHostname() string {
+	if cfg.Config.CaptchaHostname != "" {
+		return cfg.Config.CaptchaHostname
+	}
+
+	publicURL, err := url.Parse(cfg.Config.PublicURL)
+	if err != nil {
+		return ""
+	}
+	return publicURL.Hostname()
+}
@@ -0,0 +1,137 @@
+package lib
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	cfg "github.com/twibber/api/config"
+	"net/http/httptest"
+	"testing"
+)
+
+// checkCaptcha runs CheckCaptcha for the action inside a request, with the verifier returning the given result.
+func checkCaptcha(t *testing.T, verifier *FakeCaptchaVerifier, action string) error {
+	t.Helper()
+
+	captcha, hostname := Captcha, cfg.Config.CaptchaHostname
+	Captcha, cfg.Config.CaptchaHostname = verifier, "twibber.test"
+	t.Cleanup(func() {
+		Captcha, cfg.Config.CaptchaHostname = captcha, hostname
+	})
+
+	var checkErr error
+	app := fiber.New()
+	app.Post("/", func(c *fiber.Ctx) error {
+		checkErr = CheckCaptcha(c, "token", action)
+		return nil
+	})
+
+	if _, err := app.Test(httptest.NewRequest("POST", "/", nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	return checkErr
+}
+
+func score(value float64) *float64 {
+	return &value
+}
+
+func TestCheckCaptcha(t *testing.T) {
+	providerErr := errors.New("provider unavailable")
+
+	tests := []struct {
+		name     string
+		verifier FakeCaptchaVerifier
+		action   string
+		want     error
+	}{
+		{
+			name:     "passing",
+			verifier: FakeCaptchaVerifier{Result: CaptchaResult{Success: true, Score: score(0.9), Action: CaptchaRegister, Hostname: "twibber.test"}},
+			action:   CaptchaRegister,
+		},
+		{
+			name:     "without score or action",
+			verifier: FakeCaptchaVerifier{Result: CaptchaResult{Success: true, Hostname: "TWIBBER.test"}},
+			action:   CaptchaLogin,
+		},
+		{
+			name:     "unsuccessful",
+			verifier: FakeCaptchaVerifier{Result: CaptchaResult{Hostname: "twibber.test", ErrorCodes: []string{"invalid-input-response"}}},
+			action:   CaptchaLogin,
+			want:     ErrInvalidCaptcha,
+		},
+		{
+			name:     "low score",
+			verifier: FakeCaptchaVerifier{Result: CaptchaResult{Success: true, Score: score(0.4), Action: CaptchaRegister, Hostname: "twibber.test"}},
+			action:   CaptchaRegister,
+			want:     ErrInvalidCaptcha,
+		},
+		{
+			name:     "low score for an action without a threshold",
+			verifier: FakeCaptchaVerifier{Result: CaptchaResult{Success: true, Score: score(0.2), Action: CaptchaMagicLink, Hostname: "twibber.test"}},
+			action:   CaptchaMagicLink,
+			want:     ErrInvalidCaptcha,
+		},
+		{
+			name:     "wrong hostname",
+			verifier: FakeCaptchaVerifier{Result: CaptchaResult{Success: true, Score: score(0.9), Action: CaptchaLogin, Hostname: "twibber.example"}},
+			action:   CaptchaLogin,
+			want:     ErrInvalidCaptcha,
+		},
+		{
+			name:     "action mismatch",
+			verifier: FakeCaptchaVerifier{Result: CaptchaResult{Success: true, Score: score(0.9), Action: CaptchaLogin, Hostname: "twibber.test"}},
+			action:   CaptchaRegister,
+			want:     ErrInvalidCaptcha,
+		},
+		{
+			name:     "provider error",
+			verifier: FakeCaptchaVerifier{Err: providerErr},
+			action:   CaptchaLogin,
+			want:     providerErr,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := checkCaptcha(t, &test.verifier, test.action); err != test.want {
+				t.Fatalf("got %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestNewCaptchaVerifierRequiresSecret(t *testing.T) {
+	debug := cfg.Config.Debug
+	cfg.Config.Debug = true
+	t.Cleanup(func() {
+		cfg.Config.Debug = debug
+	})
+
+	for _, provider := range []string{"", "recaptcha", "hcaptcha", "turnstile"} {
+		if _, err := NewCaptchaVerifier(provider, ""); err == nil {
+			t.Fatalf("provider %q without a secret was accepted", provider)
+		}
+
+		verifier, err := NewCaptchaVerifier(provider, "secret")
+		if err != nil {
+			t.Fatalf("provider %q: %v", provider, err)
+		}
+		if _, ok := verifier.(*SiteVerifier); !ok {
+			t.Fatalf("provider %q returned %T, want *SiteVerifier", provider, verifier)
+		}
+	}
+
+	if _, err := NewCaptchaVerifier("unknown", "secret"); err == nil {
+		t.Fatal("unknown provider was accepted")
+	}
+
+	verifier, err := NewCaptchaVerifier("none", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := verifier.(NoopCaptchaVerifier); !ok {
+		t.Fatalf("provider \"none\" returned %T, want NoopCaptchaVerifier", verifier)
+	}
+}
@@ -20,7 +20,8 @@ func ClearAuth(c *fiber.Ctx) {
 	})
 }
 
-// SetAuth sets the "Authorization" cookie to the provided token and expires it after the provided duration.
+// SetAuth sets the "Authorization" cookie to the provided token and expires it after the provided duration,
+// a zero duration makes it a browser session cookie.
 func SetAuth(c *fiber.Ctx, token string, exp time.Duration) {
 	c.Cookie(&fiber.Cookie{
 		Name:     "Authorization",
@@ -32,3 +33,29 @@ func SetAuth(c *fiber.Ctx, token string, exp time.Duration) {
 		SameSite: "lax",                   // Lax same-site policy to allow sending the cookie along with cross-site requests on the same domain
 	})
 }
+
+// SetOAuthState stores the OAuth state and PKCE verifier in a short-lived cookie for the callback to check.
+func SetOAuthState(c *fiber.Ctx, value string) {
+	c.Cookie(&fiber.Cookie{
+		Name:     "OAuthState",
+		Value:    value,
+		Path:     "/auth",                           // Only sent to the auth callbacks
+		Domain:   "." + cfg.Config.Domain,           // Make the cookie a wildcard to access the cookie from all subdomains of the domain
+		MaxAge:   int((10 * time.Minute).Seconds()), // The flow must be completed within 10 minutes
+		HTTPOnly: true,                              // Prevents JavaScript from accessing the cookie
+		SameSite: "lax",                             // Lax is required so the cookie is sent on the provider's redirect back
+	})
+}
+
+// ClearOAuthState removes the OAuth state cookie once the callback has been handled.
+func ClearOAuthState(c *fiber.Ctx) {
+	c.Cookie(&fiber.Cookie{
+		Name:     "OAuthState",
+		Value:    "",
+		Path:     "/auth",
+		Domain:   "." + cfg.Config.Domain,
+		MaxAge:   -1, // Expires the cookie immediately
+		HTTPOnly: true,
+		SameSite: "lax",
+	})
+}
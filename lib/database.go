@@ -2,6 +2,7 @@ package lib
 
 import (
 	"fmt"
+	"github.com/gofiber/fiber/v2/utils"
 	log "github.com/sirupsen/logrus" // Logrus for structured logging
 	cfg "github.com/twibber/api/config"
 	"github.com/twibber/api/lib/gormLogger" // Custom GORM logger from the lib package
@@ -16,8 +17,9 @@ var (
 	DB *gorm.DB // DB is a global variable for the database connection
 )
 
-// init function establishes a connection to the database and logs the event.
-func init() {
+// ConnectDB establishes the connection to the database, logs the event and sets up the stores kept in it.
+// It is called on startup rather than on import, so packages can be tested without a database.
+func ConnectDB() {
 	// Opens a new database connection using the provided credentials and configuration.
 	if conn, err := gorm.Open(postgres.Open(fmt.Sprintf("user=%s password=%s host=%s port=%s dbname=%s",
 		cfg.Config.DBUsername, cfg.Config.DBPassword, cfg.Config.DBHost, cfg.Config.DBPort, cfg.Config.DBName)), &gorm.Config{
@@ -35,17 +37,51 @@ func init() {
 			"database": cfg.Config.DBName,
 		}).Info("initiated database connection")
 		DB = conn // Set the global DB variable to the connection
+
+		// Stores which may be kept in the database, depending on the configuration
+		Attempts = NewAttemptStore(cfg.Config.AttemptStore)
+		RateLimits = NewRateLimitStore(cfg.Config.RateLimitStore)
+		Timelines = NewTimelineStore(cfg.Config.TimelineStore)
+		StartTimelineWorkers()
 	}
 }
 
 // MigrateDB applies the auto migrations for the database models.
 func MigrateDB() {
+	// Drops materialized timelines from before each post and repost had its own entry, they are rebuilt when next read
+	if err := migrateTimelineEntries(); err != nil {
+		log.WithError(err).Fatal("could not migrate timeline entries")
+	}
+
 	// AutoMigrate will create or update database tables according to the models
 	if err := DB.Migrator().AutoMigrate(models.Models...); err != nil {
 		// Logs and stops the application if migration fails
 		log.WithError(err).Fatal("could not migrate database")
 	}
 
+	// Converts sessions created before tokens were hashed
+	if err := migrateSessionTokens(); err != nil {
+		log.WithError(err).Fatal("could not migrate session tokens")
+	}
+
+	// Places posts created before conversations were tracked into theirs
+	if err := migrateConversations(); err != nil {
+		log.WithError(err).Fatal("could not migrate post conversations")
+	}
+
+	// Keeps conversation mutes when the post at the root of the conversation is deleted
+	if err := dropConversationMuteKey(); err != nil {
+		log.WithError(err).Fatal("could not migrate conversation mutes")
+	}
+
+	// Creates the default roles and moves admins over to them
+	if err := seedRoles(); err != nil {
+		log.WithError(err).Fatal("could not seed roles")
+	}
+	if err := migrateAdmins(); err != nil {
+		log.WithError(err).Fatal("could not migrate admins to roles")
+	}
+
 	// Retrieves and logs the names of all migrated models
 	modelNames := make([]string, 0)
 	for _, n := range models.Models {
@@ -55,3 +91,99 @@ func MigrateDB() {
 	// Logs the successful migration of all models
 	log.WithField("models", modelNames).Info("migrated all database models")
 }
+
+// migrateConversations sets the conversation of posts created before conversations were tracked,
+// walking each chain of replies down from the post it started at.
+func migrateConversations() error {
+	var missing int64
+	if err := DB.Model(&models.Post{}).Where("conversation_id IS NULL OR conversation_id = ''").Count(&missing).Error; err != nil {
+		return err
+	}
+
+	if missing == 0 {
+		return nil
+	}
+
+	if err := DB.Exec(`WITH RECURSIVE conversations AS (
+			SELECT id, id AS conversation_id FROM posts WHERE type <> ? OR parent_id IS NULL
+			UNION ALL
+			SELECT posts.id, conversations.conversation_id FROM posts
+			JOIN conversations ON posts.parent_id = conversations.id
+			WHERE posts.type = ?
+		)
+		UPDATE posts SET conversation_id = conversations.conversation_id
+		FROM conversations
+		WHERE posts.id = conversations.id AND (posts.conversation_id IS NULL OR posts.conversation_id = '')`,
+		models.PostTypeReply, models.PostTypeReply).Error; err != nil {
+		return err
+	}
+
+	log.WithField("posts", missing).Info("placed posts into their conversations")
+
+	return nil
+}
+
+// dropConversationMuteKey drops the foreign key from conversation mutes to the post at the root of the conversation,
+// which deleted the mutes along with the post even though the rest of the conversation remains.
+func dropConversationMuteKey() error {
+	const name = "fk_conversation_mutes_conversation"
+	if !DB.Migrator().HasConstraint(&models.ConversationMute{}, name) {
+		return nil
+	}
+
+	return DB.Migrator().DropConstraint(&models.ConversationMute{}, name)
+}
+
+// migrateTimelineEntries drops the timeline entries if they are still keyed by original rather than by post,
+// along with the markers of which timelines have been built, for the auto migration to recreate.
+func migrateTimelineEntries() error {
+	if !DB.Migrator().HasTable(&models.TimelineEntry{}) {
+		return nil
+	}
+
+	var keyed int64
+	if err := DB.Raw(`SELECT COUNT(*) FROM information_schema.table_constraints AS constraints
+		JOIN information_schema.key_column_usage AS columns
+			ON columns.constraint_name = constraints.constraint_name AND columns.table_schema = constraints.table_schema
+		WHERE constraints.table_schema = CURRENT_SCHEMA() AND constraints.table_name = ?
+			AND constraints.constraint_type = 'PRIMARY KEY' AND columns.column_name = ?`,
+		"timeline_entries", "post_id").Scan(&keyed).Error; err != nil {
+		return err
+	}
+
+	if keyed > 0 {
+		return nil
+	}
+
+	if err := DB.Migrator().DropTable(&models.TimelineEntry{}, &models.TimelineBuild{}); err != nil {
+		return err
+	}
+
+	log.Info("dropped timeline entries keyed by original, timelines will be rebuilt when read")
+
+	return nil
+}
+
+// migrateSessionTokens converts sessions which still use their raw token as the ID.
+// The hash of the old ID becomes the token hash, so existing cookies keep working, and a new public ID is assigned.
+func migrateSessionTokens() error {
+	var ids []string
+	if err := DB.Model(&models.Session{}).Where("token_hash IS NULL OR token_hash = ''").Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := DB.Model(&models.Session{}).Where("id = ?", id).Updates(map[string]any{
+			"id":         utils.UUIDv4(),
+			"token_hash": HashToken(id),
+		}).Error; err != nil {
+			return err
+		}
+	}
+
+	if len(ids) > 0 {
+		log.WithField("sessions", len(ids)).Info("converted sessions to hashed tokens")
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package lib
+
+import (
+	"github.com/mssola/useragent"
+	cfg "github.com/twibber/api/config"
+	"github.com/twibber/api/mailer"
+	"github.com/twibber/api/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// SessionRevokeDuration is how long the "this wasn't me" link of a new sign-in email remains valid.
+const SessionRevokeDuration = 7 * 24 * time.Hour
+
+// Device types parsed from a user agent.
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceBot     = "bot"
+)
+
+// maxDeviceInfoLength is the size of the browser and OS columns of a session.
+const maxDeviceInfoLength = 64
+
+// ParseUserAgent fills in the browser, OS and device type of the session info from its user agent.
+func ParseUserAgent(info *models.SessionInfo) {
+	ua := useragent.New(info.UserAgent)
+
+	name, version := ua.Browser()
+	info.Browser = truncate(strings.TrimSpace(name+" "+version), maxDeviceInfoLength)
+	info.OS = truncate(ua.OS(), maxDeviceInfoLength)
+
+	switch {
+	case ua.Bot():
+		info.Device = DeviceBot
+	case ua.Mobile():
+		info.Device = DeviceMobile
+	default:
+		info.Device = DeviceDesktop
+	}
+}
+
+// IPPrefix returns the network an IP address belongs to, a /24 for IPv4 and a /48 for IPv6,
+// so that devices are not reported as new each time their address changes within the same network.
+func IPPrefix(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String() + "/48"
+}
+
+// DeviceFingerprint identifies the browser, OS and network of a login.
+// Versions are left out so that updating a browser does not make it a new device.
+func DeviceFingerprint(userAgent, ip string) string {
+	ua := useragent.New(userAgent)
+	browser, _ := ua.Browser()
+
+	return HashToken(strings.Join([]string{browser, ua.OSInfo().Name, IPPrefix(ip)}, "|"))
+}
+
+// TrackDevice records the device of a new session against its connection and reports whether it has not been seen before.
+// A connection's first device is never reported, as there is nothing to compare it against.
+func TrackDevice(tx *gorm.DB, session *models.Session) (string, bool, error) {
+	fingerprint := DeviceFingerprint(session.Info.UserAgent, session.Info.LastIP)
+	now := time.Now()
+
+	var known int64
+	if err := tx.Model(&models.KnownDevice{}).Where("connection_id = ?", session.ConnectionID).Count(&known).Error; err != nil {
+		return "", false, err
+	}
+
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.KnownDevice{
+		ConnectionID: session.ConnectionID,
+		Fingerprint:  fingerprint,
+		LastSeenAt:   now,
+	})
+	if result.Error != nil {
+		return "", false, result.Error
+	}
+
+	// The device was already known, so only when it was last seen changes.
+	if result.RowsAffected == 0 {
+		return fingerprint, false, tx.Model(&models.KnownDevice{}).
+			Where("connection_id = ? AND fingerprint = ?", session.ConnectionID, fingerprint).
+			Update("last_seen_at", now).Error
+	}
+
+	return fingerprint, known > 0, nil
+}
+
+// NewSignInNotice prepares the email telling the owner of the session's connection about a login from a new device,
+// with a link to revoke the session if it was not them. The link is issued in the transaction,
+// so the email is only sent by the caller once the transaction has been committed.
+func NewSignInNotice(tx *gorm.DB, session *models.Session, fingerprint string) (*mailer.NewSignInDTO, error) {
+	var connection models.Connection
+	if err := tx.Where("id = ?", session.ConnectionID).Preload("User").First(&connection).Error; err != nil {
+		return nil, err
+	}
+
+	token, err := IssueToken(tx, models.TokenSessionRevoke, connection.ID, fingerprint+":"+session.ID, SessionRevokeDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mailer.NewSignInDTO{
+		Defaults: mailer.Defaults{
+			Email: connection.User.Email,
+			Name:  connection.User.Username,
+		},
+		Browser: session.Info.Browser,
+		OS:      session.Info.OS,
+		IP:      session.Info.LastIP,
+		Time:    session.CreatedAt.UTC().Format(time.RFC1123),
+		Link:    cfg.Config.PublicURL + "/auth/session/revoke?token=" + url.QueryEscape(SignValue(token)),
+	}, nil
+}
+
+// truncate shortens the string to at most max bytes, without splitting a character.
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
@@ -0,0 +1,44 @@
+package lib
+
+import (
+	"github.com/twibber/api/models"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestParseUserAgentFitsColumns(t *testing.T) {
+	info := models.SessionInfo{
+		UserAgent: "Mozilla/5.0 (X11; " + strings.Repeat("Ünïcödé ", 20) + ") Gecko/20100101 " +
+			"Firefox/" + strings.Repeat("9", 100),
+	}
+	ParseUserAgent(&info)
+
+	for name, value := range map[string]string{"browser": info.Browser, "os": info.OS} {
+		if len(value) > maxDeviceInfoLength {
+			t.Fatalf("%s is %d bytes, want at most %d", name, len(value), maxDeviceInfoLength)
+		}
+		if !utf8.ValidString(value) {
+			t.Fatalf("%s %q was cut in the middle of a character", name, value)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		max  int
+		want string
+	}{
+		{"firefox", 64, "firefox"},
+		{"firefox", 4, "fire"},
+		{"héllo", 2, "h"},
+		{"héllo", 3, "hé"},
+	}
+
+	for _, test := range tests {
+		if got := truncate(test.s, test.max); got != test.want {
+			t.Fatalf("truncate(%q, %d) = %q, want %q", test.s, test.max, got, test.want)
+		}
+	}
+}
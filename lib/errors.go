@@ -4,9 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	cfg "github.com/twibber/api/config"
+	"math"
 	"net/http"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"       // Fiber web framework
 	"github.com/gofiber/fiber/v2/utils" // Utility functions for Fiber
@@ -45,6 +48,8 @@ type Error struct {
 	Code    string        `json:"code"`              // API-specific error code
 	Message string        `json:"message"`           // Human-readable error message
 	Details *ErrorDetails `json:"details,omitempty"` // Optional details about the error
+
+	RetryAfter time.Duration `json:"-"` // When set, sent as the Retry-After header
 }
 
 // Error formats the error message string.
@@ -56,6 +61,8 @@ func (e Error) Error() string {
 type ErrorDetails struct {
 	Fields []ErrorField `json:"fields,omitempty"` // Specific fields related to the error
 	Debug  any          `json:"debug,omitempty"`  // Debug information, included only if debugging is enabled
+
+	Suspension *SuspensionDetails `json:"suspension,omitempty"` // Why and until when the account is suspended
 }
 
 // ErrorField provides detailed errors for specific fields in the request.
@@ -82,13 +89,28 @@ func NewError(status int, message string, details *ErrorDetails, code ...string)
 	}
 }
 
+// WithRetryAfter returns a copy of the error telling the client when the request may be retried.
+func (e Error) WithRetryAfter(d time.Duration) Error {
+	e.RetryAfter = d
+	return e
+}
+
 // ErrorHandler is a custom error handler for the Fiber application.
 func ErrorHandler(c *fiber.Ctx, err error) error {
 	// Handles different types of errors and formats them for API responses
 	switch err.(type) {
 	case Error:
 		e := err.(Error)
+		if e.RetryAfter > 0 {
+			// Rounded up so clients never retry early
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(e.RetryAfter.Seconds()))))
+		}
 		return c.Status(e.Status).JSON(Response{Success: false, Data: e})
+	case OAuthError:
+		// OAuth2 endpoints use the error format defined by RFC 6749
+		e := err.(OAuthError)
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		return c.Status(e.Status).JSON(e)
 	case *fiber.Error:
 		fiberErr := err.(*fiber.Error)
 		e := NewError(fiberErr.Code, fiberErr.Message, nil)
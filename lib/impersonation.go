@@ -0,0 +1,101 @@
+package lib
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	log "github.com/sirupsen/logrus"
+	cfg "github.com/twibber/api/config"
+	"github.com/twibber/api/models"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Errors returned when impersonating users.
+var (
+	ErrImpersonating     = NewError(fiber.StatusForbidden, "This action cannot be performed while impersonating a user.", nil, "IMPERSONATING")
+	ErrCannotImpersonate = NewError(fiber.StatusForbidden, "Staff members cannot be impersonated.", nil, "CANNOT_IMPERSONATE")
+	ErrNoConnections     = NewError(fiber.StatusBadRequest, "The user has no connections to impersonate.", nil, "NO_CONNECTIONS")
+)
+
+// Impersonate creates a short-lived session letting the actor act as the user, and returns its token.
+// The session is never extended past the impersonation lifetime, and the start of it is recorded in the audit log.
+func Impersonate(tx *gorm.DB, c *fiber.Ctx, actorID string, user *models.User) (string, *models.Session, error) {
+	// Staff cannot be impersonated, so impersonation cannot be used to gain permissions the actor does not have.
+	staff, err := IsStaff(tx, user.ID)
+	if err != nil {
+		return "", nil, err
+	}
+	if staff {
+		return "", nil, ErrCannotImpersonate
+	}
+
+	var connection models.Connection
+	if err := tx.Where(models.Connection{UserID: user.ID}).Order("created_at").First(&connection).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", nil, ErrNoConnections
+		}
+		return "", nil, err
+	}
+
+	token := GenerateString(64)
+
+	// Device tracking is skipped, as the user should not be alerted to a sign-in from the staff member's device.
+	session, _ := NewSession(c, token, false)
+	expiresAt := time.Now().Add(cfg.Config.ImpersonationLifetime)
+	session.ConnectionID = connection.ID
+	session.ExpiresAt = expiresAt
+	session.MaxExpiresAt = &expiresAt
+	session.ImpersonatorID = &actorID
+	session.Impersonated = true
+
+	if err := tx.Create(&session).Error; err != nil {
+		return "", nil, err
+	}
+
+	if err := tx.Create(&models.AuditLog{
+		ActorID:   actorID,
+		SubjectID: &user.ID,
+		SessionID: &session.ID,
+		Action:    models.AuditImpersonateStart,
+		IP:        c.IP(),
+	}).Error; err != nil {
+		return "", nil, err
+	}
+
+	return token, &session, nil
+}
+
+// AuditImpersonation handles the request with next, recording it against the staff member when the session
+// is an impersonation session. The error is handled here so the status the request was answered with can be recorded.
+func AuditImpersonation(c *fiber.Ctx, session *models.Session, next func() error) error {
+	if session.ImpersonatorID == nil {
+		return next()
+	}
+
+	if err := next(); err != nil {
+		if err := c.App().ErrorHandler(c, err); err != nil {
+			return err
+		}
+	}
+
+	RecordImpersonatedRequest(c, session)
+
+	return nil
+}
+
+// RecordImpersonatedRequest records a request made with an impersonation session against the staff member,
+// along with the status it was answered with.
+func RecordImpersonatedRequest(c *fiber.Ctx, session *models.Session) {
+	if err := DB.Create(&models.AuditLog{
+		ActorID:   *session.ImpersonatorID,
+		SubjectID: &session.Connection.UserID,
+		SessionID: &session.ID,
+		Action:    models.AuditImpersonateRequest,
+		Method:    c.Method(),
+		Path:      c.Path(),
+		Status:    c.Response().StatusCode(),
+		IP:        c.IP(),
+	}).Error; err != nil {
+		log.WithError(err).WithField("session", session.ID).Error("could not record impersonated request")
+	}
+}
@@ -0,0 +1,184 @@
+package lib
+
+import (
+	"encoding/base64"
+	"github.com/alexedwards/argon2id"
+	"github.com/gofiber/fiber/v2"
+	"github.com/skip2/go-qrcode"
+	"github.com/twibber/api/models"
+	"gorm.io/gorm"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// MFAChallengeDuration is how long a user has to enter their MFA code after entering their password.
+const MFAChallengeDuration = 5 * time.Minute
+
+// ErrInvalidMFACode is returned when an MFA code is incorrect or has already been used.
+var ErrInvalidMFACode = NewError(fiber.StatusBadRequest, "Invalid code provided.", &ErrorDetails{
+	Fields: []ErrorField{
+		{Name: "code", Errors: []string{"The code provided is invalid or has already been used."}},
+	},
+})
+
+// MFAChallengeResponse is returned in place of a session when a login requires an MFA code.
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"` // Always true, lets clients tell the response apart from a completed login
+	Challenge   string `json:"challenge"`    // Token to submit along with the MFA code
+}
+
+// MFAEnrollment holds the details an authenticator app needs to be set up.
+type MFAEnrollment struct {
+	Secret string `json:"secret"`  // Base32 secret for manual entry
+	URI    string `json:"uri"`     // otpauth:// URI understood by authenticator apps
+	QRCode string `json:"qr_code"` // PNG QR code of the URI as a data URL
+}
+
+// CreateMFAChallenge records a login awaiting an MFA code and returns the challenge token.
+func CreateMFAChallenge(tx *gorm.DB, connectionID string, rememberMe bool) (string, error) {
+	token := GenerateString(64)
+
+	if err := tx.Create(&models.MFAChallenge{
+		BaseModel: models.BaseModel{
+			ID: HashToken(token),
+		},
+		ConnectionID: connectionID,
+		RememberMe:   rememberMe,
+		ExpiresAt:    time.Now().Add(MFAChallengeDuration),
+	}).Error; err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// NewMFAEnrollment generates a new MFA secret along with its otpauth:// URI and QR code.
+func NewMFAEnrollment(accountName string) (*MFAEnrollment, error) {
+	secret, err := GenerateSecureRandomBase32(32)
+	if err != nil {
+		return nil, err
+	}
+
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", "Twibber")
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", "6")
+	q.Set("period", "30")
+
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/Twibber:" + accountName,
+		RawQuery: q.Encode(),
+	}
+
+	png, err := qrcode.Encode(uri.String(), qrcode.Medium, 256)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MFAEnrollment{
+		Secret: secret,
+		URI:    uri.String(),
+		QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	}, nil
+}
+
+// UseMFACode validates a code against the secret and records its time step on the user so it cannot be used again.
+func UseMFACode(tx *gorm.DB, user *models.User, secret, code string) error {
+	step, ok := ValidateMFACode(secret, code, user.MFALastStep)
+	if !ok {
+		return ErrInvalidMFACode
+	}
+
+	// Only advance the step if no other request has used this or a later code in the meantime.
+	result := tx.Model(&models.User{}).
+		Where("id = ? AND mfa_last_step < ?", user.ID, step).
+		Update("mfa_last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrInvalidMFACode
+	}
+
+	user.MFALastStep = step
+	return nil
+}
+
+// RecoveryCodeCount is the number of recovery codes issued to a user at a time.
+const RecoveryCodeCount = 10
+
+// GenerateRecoveryCodes replaces the user's recovery codes with a new set and returns them in plain text.
+func GenerateRecoveryCodes(tx *gorm.DB, userID string) ([]string, error) {
+	if err := tx.Where(models.RecoveryCode{UserID: userID}).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, RecoveryCodeCount)
+	records := make([]models.RecoveryCode, RecoveryCodeCount)
+
+	for i := range codes {
+		code := strings.ToLower(GenerateString(10))
+
+		hash, err := argon2id.CreateHash(code, &ArgonConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		codes[i] = code[:5] + "-" + code[5:]
+		records[i] = models.RecoveryCode{
+			UserID: userID,
+			Hash:   hash,
+		}
+	}
+
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode checks the code against the user's unused recovery codes, marking the matching code as used.
+func UseRecoveryCode(tx *gorm.DB, userID, code, ip string) error {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+
+	var recoveryCodes []models.RecoveryCode
+	if err := tx.Where("user_id = ? AND used_at IS NULL", userID).Find(&recoveryCodes).Error; err != nil {
+		return err
+	}
+
+	for _, recoveryCode := range recoveryCodes {
+		match, err := argon2id.ComparePasswordAndHash(code, recoveryCode.Hash)
+		if err != nil {
+			return err
+		}
+
+		if !match {
+			continue
+		}
+
+		// Only mark the code as used if no other request has used it in the meantime.
+		result := tx.Model(&models.RecoveryCode{}).
+			Where("id = ? AND used_at IS NULL", recoveryCode.ID).
+			Updates(map[string]any{
+				"used_at": time.Now(),
+				"used_ip": ip,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			break
+		}
+
+		return nil
+	}
+
+	return ErrInvalidMFACode
+}
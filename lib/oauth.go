@@ -0,0 +1,234 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	cfg "github.com/twibber/api/config"
+	"github.com/twibber/api/models"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// oauthClient is the HTTP client used to talk to OAuth providers.
+var oauthClient = &http.Client{Timeout: 10 * time.Second}
+
+// OAuthUser holds the profile details returned by an OAuth provider.
+type OAuthUser struct {
+	ID            string // Provider specific user ID
+	Email         string // Email address of the user
+	EmailVerified bool   // Whether the provider has verified the email address
+	Username      string // Preferred username, used as a base when creating a new user
+	Name          string // Display name of the user
+	Avatar        string // URL to the user's avatar image
+}
+
+// OAuthProvider describes an OAuth2 provider which can be used to log in.
+type OAuthProvider struct {
+	Type         models.ConnectionType // Connection type created by this provider
+	ClientID     string                // OAuth client ID
+	ClientSecret string                // OAuth client secret
+	AuthURL      string                // Authorization endpoint
+	TokenURL     string                // Token exchange endpoint
+	UserInfoURL  string                // User info endpoint
+	Scopes       []string              // Scopes requested during authorization
+
+	fetchUser func(p *OAuthProvider, accessToken string) (*OAuthUser, error)
+}
+
+// GetOAuthProvider returns the OAuth provider for the given connection type from the current configuration.
+func GetOAuthProvider(connType models.ConnectionType) (*OAuthProvider, bool) {
+	switch connType {
+	case models.ProviderGoogleType:
+		return &OAuthProvider{
+			Type:         models.ProviderGoogleType,
+			ClientID:     cfg.Config.GoogleClient,
+			ClientSecret: cfg.Config.GoogleSecret,
+			AuthURL:      cfg.Config.GoogleAuthURL,
+			TokenURL:     cfg.Config.GoogleTokenURL,
+			UserInfoURL:  cfg.Config.GoogleUserInfoURL,
+			Scopes:       []string{"openid", "email", "profile"},
+			fetchUser:    fetchGoogleUser,
+		}, true
+	case models.ProviderGitHubType:
+		return &OAuthProvider{
+			Type:         models.ProviderGitHubType,
+			ClientID:     cfg.Config.GitHubClient,
+			ClientSecret: cfg.Config.GitHubSecret,
+			AuthURL:      cfg.Config.GitHubAuthURL,
+			TokenURL:     cfg.Config.GitHubTokenURL,
+			UserInfoURL:  cfg.Config.GitHubUserInfoURL,
+			Scopes:       []string{"read:user", "user:email"},
+			fetchUser:    fetchGitHubUser,
+		}, true
+	}
+
+	return nil, false
+}
+
+// RedirectURL returns the callback URL registered with the provider.
+func (p *OAuthProvider) RedirectURL() string {
+	return fmt.Sprintf("%s/auth/%s/callback", strings.TrimRight(cfg.Config.APIURL, "/"), p.Type)
+}
+
+// AuthCodeURL builds the URL the user is redirected to in order to authorise the application.
+func (p *OAuthProvider) AuthCodeURL(state, verifier string) string {
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", p.RedirectURL())
+	q.Set("scope", strings.Join(p.Scopes, " "))
+	q.Set("state", state)
+	q.Set("code_challenge", PKCEChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+
+	return p.AuthURL + "?" + q.Encode()
+}
+
+// Exchange trades an authorization code for an access token.
+func (p *OAuthProvider) Exchange(code, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL())
+	form.Set("client_id", p.ClientID)
+	form.Set("client_secret", p.ClientSecret)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequest(http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var body struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := doOAuthRequest(req, &body); err != nil {
+		return "", err
+	}
+
+	if body.AccessToken == "" {
+		return "", fmt.Errorf("%s token exchange failed: %s %s", p.Type, body.Error, body.ErrorDescription)
+	}
+
+	return body.AccessToken, nil
+}
+
+// User fetches the profile of the user the access token belongs to.
+func (p *OAuthProvider) User(accessToken string) (*OAuthUser, error) {
+	return p.fetchUser(p, accessToken)
+}
+
+// fetchGoogleUser reads the OpenID Connect user info of a Google account.
+func fetchGoogleUser(p *OAuthProvider, accessToken string) (*OAuthUser, error) {
+	var body struct {
+		Sub           string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+		Picture       string `json:"picture"`
+	}
+	if err := getOAuthJSON(p.UserInfoURL, accessToken, &body); err != nil {
+		return nil, err
+	}
+
+	if body.Sub == "" {
+		return nil, fmt.Errorf("google user info did not contain a subject")
+	}
+
+	return &OAuthUser{
+		ID:            body.Sub,
+		Email:         body.Email,
+		EmailVerified: body.EmailVerified,
+		Username:      strings.Split(body.Email, "@")[0],
+		Name:          body.Name,
+		Avatar:        body.Picture,
+	}, nil
+}
+
+// fetchGitHubUser reads the profile and primary email of a GitHub account.
+func fetchGitHubUser(p *OAuthProvider, accessToken string) (*OAuthUser, error) {
+	var body struct {
+		ID        int64  `json:"id"`
+		Login     string `json:"login"`
+		Name      string `json:"name"`
+		AvatarURL string `json:"avatar_url"`
+	}
+	if err := getOAuthJSON(p.UserInfoURL, accessToken, &body); err != nil {
+		return nil, err
+	}
+
+	if body.ID == 0 {
+		return nil, fmt.Errorf("github user info did not contain an id")
+	}
+
+	user := &OAuthUser{
+		ID:       strconv.FormatInt(body.ID, 10),
+		Username: body.Login,
+		Name:     body.Name,
+		Avatar:   body.AvatarURL,
+	}
+
+	// The profile email may be hidden, so the primary address is read from the emails endpoint.
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getOAuthJSON(cfg.Config.GitHubEmailsURL, accessToken, &emails); err != nil {
+		return nil, err
+	}
+
+	for _, email := range emails {
+		if email.Primary {
+			user.Email = email.Email
+			user.EmailVerified = email.Verified
+			break
+		}
+	}
+
+	return user, nil
+}
+
+// getOAuthJSON performs an authenticated GET request against a provider and decodes the JSON response.
+func getOAuthJSON(endpoint, accessToken string, out any) error {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	return doOAuthRequest(req, out)
+}
+
+// doOAuthRequest executes a request against a provider and decodes the JSON response.
+func doOAuthRequest(req *http.Request, out any) error {
+	resp, err := oauthClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("oauth provider returned %d: %s", resp.StatusCode, body)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// PKCEChallenge derives the S256 code challenge for a PKCE code verifier.
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,266 @@
+package lib
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/twibber/api/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
+)
+
+// Lifetimes of the credentials issued when Twibber acts as an OAuth2 provider.
+const (
+	OAuthCodeDuration         = 10 * time.Minute
+	OAuthAccessTokenDuration  = time.Hour
+	OAuthRefreshTokenDuration = 30 * 24 * time.Hour
+)
+
+// RefreshTokenPrefix starts every OAuth refresh token, so they can be told apart from access tokens.
+const RefreshTokenPrefix = "twr_"
+
+// OAuthError is an error returned from the OAuth2 endpoints in the format defined by RFC 6749.
+type OAuthError struct {
+	Status      int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// Error formats the error message string.
+func (e OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// Errors returned from the OAuth2 token endpoints.
+var (
+	ErrOAuthInvalidRequest = OAuthError{fiber.StatusBadRequest, "invalid_request", "The request is missing a parameter or is otherwise malformed."}
+	ErrOAuthInvalidClient  = OAuthError{fiber.StatusUnauthorized, "invalid_client", "Client authentication failed."}
+	ErrOAuthInvalidGrant   = OAuthError{fiber.StatusBadRequest, "invalid_grant", "The authorization code or refresh token is invalid or has expired."}
+	ErrOAuthUnsupported    = OAuthError{fiber.StatusBadRequest, "unsupported_grant_type", "The grant type is not supported."}
+)
+
+// OAuthTokenResponse is the response of the token endpoint, as defined by RFC 6749.
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// ParseScopes parses a space separated list of scopes, reporting false if it is empty or contains an unknown scope.
+func ParseScopes(scope string) ([]string, bool) {
+	scopes := make([]string, 0)
+	for _, s := range strings.Fields(scope) {
+		if !ValidScope(s) {
+			return nil, false
+		}
+		if !HasScopes(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+
+	return scopes, len(scopes) > 0
+}
+
+// CreateOAuthClient registers a client and returns its secret, which is empty for public clients.
+func CreateOAuthClient(tx *gorm.DB, client *models.OAuthClient) (string, error) {
+	var secret string
+	if !client.Public {
+		secret = GenerateString(64)
+		client.SecretHash = HashToken(secret)
+	}
+
+	if err := tx.Create(client).Error; err != nil {
+		return "", err
+	}
+
+	return secret, nil
+}
+
+// GetOAuthClient returns the client with the given ID.
+func GetOAuthClient(tx *gorm.DB, clientID string) (*models.OAuthClient, error) {
+	var client models.OAuthClient
+	if err := tx.Where(models.OAuthClient{
+		BaseModel: models.BaseModel{ID: clientID},
+	}).First(&client).Error; err != nil {
+		return nil, err
+	}
+
+	return &client, nil
+}
+
+// AuthenticateOAuthClient checks the credentials of a client calling the token endpoints.
+// Public clients cannot keep a secret, so they only identify themselves.
+func AuthenticateOAuthClient(tx *gorm.DB, clientID, secret string) (*models.OAuthClient, error) {
+	if clientID == "" {
+		return nil, ErrOAuthInvalidClient
+	}
+
+	client, err := GetOAuthClient(tx, clientID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOAuthInvalidClient
+		}
+		return nil, err
+	}
+
+	if client.Public {
+		return client, nil
+	}
+
+	if secret == "" || subtle.ConstantTimeCompare([]byte(HashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, ErrOAuthInvalidClient
+	}
+
+	return client, nil
+}
+
+// ValidRedirectURI reports whether the URI is one the client registered, which must match exactly.
+func ValidRedirectURI(client *models.OAuthClient, uri string) bool {
+	for _, registered := range client.RedirectURIs {
+		if registered == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// IssueOAuthCode creates an authorization code for the client to exchange for tokens, only its hash is stored.
+func IssueOAuthCode(tx *gorm.DB, client *models.OAuthClient, userID, redirectURI string, scopes []string, challenge string) (string, error) {
+	code := GenerateString(64)
+
+	if err := tx.Create(&models.OAuthCode{
+		BaseModel:     models.BaseModel{ID: HashToken(code)},
+		ClientID:      client.ID,
+		UserID:        userID,
+		RedirectURI:   redirectURI,
+		Scopes:        scopes,
+		CodeChallenge: challenge,
+		ExpiresAt:     time.Now().Add(OAuthCodeDuration),
+	}).Error; err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// ExchangeOAuthCode consumes an authorization code issued to the client, checking the redirect URI and PKCE verifier.
+func ExchangeOAuthCode(tx *gorm.DB, client *models.OAuthClient, code, redirectURI, verifier string) (*models.OAuthCode, error) {
+	var oauthCode models.OAuthCode
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where(models.OAuthCode{
+		BaseModel: models.BaseModel{ID: HashToken(code)},
+	}).First(&oauthCode).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOAuthInvalidGrant
+		}
+		return nil, err
+	}
+
+	// The code is single use.
+	if err := tx.Delete(&oauthCode).Error; err != nil {
+		return nil, err
+	}
+
+	if oauthCode.ClientID != client.ID ||
+		oauthCode.RedirectURI != redirectURI ||
+		oauthCode.ExpiresAt.Before(time.Now()) ||
+		subtle.ConstantTimeCompare([]byte(PKCEChallenge(verifier)), []byte(oauthCode.CodeChallenge)) != 1 {
+		return nil, ErrOAuthInvalidGrant
+	}
+
+	return &oauthCode, nil
+}
+
+// IssueOAuthTokens creates an access token and a refresh token for the client to act on behalf of the user.
+func IssueOAuthTokens(tx *gorm.DB, client *models.OAuthClient, userID string, scopes []string) (*OAuthTokenResponse, error) {
+	expiresAt := time.Now().Add(OAuthAccessTokenDuration)
+
+	accessToken, err := createAPIToken(tx, &models.APIToken{
+		UserID:    userID,
+		ClientID:  &client.ID,
+		Name:      client.Name,
+		Scopes:    scopes,
+		ExpiresAt: &expiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken := RefreshTokenPrefix + GenerateString(48)
+	if err := tx.Create(&models.OAuthRefreshToken{
+		BaseModel: models.BaseModel{ID: HashToken(refreshToken)},
+		ClientID:  client.ID,
+		UserID:    userID,
+		Scopes:    scopes,
+		ExpiresAt: time.Now().Add(OAuthRefreshTokenDuration),
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	return &OAuthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(OAuthAccessTokenDuration.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopes, " "),
+	}, nil
+}
+
+// RefreshOAuthTokens replaces a refresh token issued to the client with a new pair of tokens.
+func RefreshOAuthTokens(tx *gorm.DB, client *models.OAuthClient, refreshToken string) (*OAuthTokenResponse, error) {
+	var token models.OAuthRefreshToken
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where(models.OAuthRefreshToken{
+		BaseModel: models.BaseModel{ID: HashToken(refreshToken)},
+		ClientID:  client.ID,
+	}).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOAuthInvalidGrant
+		}
+		return nil, err
+	}
+
+	// Refresh tokens are rotated, so each one can only be used once.
+	if err := tx.Delete(&token).Error; err != nil {
+		return nil, err
+	}
+
+	if token.ExpiresAt.Before(time.Now()) {
+		return nil, ErrOAuthInvalidGrant
+	}
+
+	return IssueOAuthTokens(tx, client, token.UserID, token.Scopes)
+}
+
+// RevokeOAuthToken revokes an access or refresh token issued to the client, unknown tokens are ignored.
+func RevokeOAuthToken(tx *gorm.DB, client *models.OAuthClient, token string) error {
+	if IsAPIToken(token) {
+		return tx.Where(models.APIToken{
+			TokenHash: HashToken(token),
+			ClientID:  &client.ID,
+		}).Delete(&models.APIToken{}).Error
+	}
+
+	return tx.Where(models.OAuthRefreshToken{
+		BaseModel: models.BaseModel{ID: HashToken(token)},
+		ClientID:  client.ID,
+	}).Delete(&models.OAuthRefreshToken{}).Error
+}
+
+// RevokeOAuthAuthorization removes a user's consent for a client along with every credential issued under it.
+func RevokeOAuthAuthorization(tx *gorm.DB, userID, clientID string) (int64, error) {
+	result := tx.Where(models.OAuthAuthorization{UserID: userID, ClientID: clientID}).Delete(&models.OAuthAuthorization{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	for _, model := range []any{&models.APIToken{}, &models.OAuthRefreshToken{}, &models.OAuthCode{}} {
+		if err := tx.Where("user_id = ? AND client_id = ?", userID, clientID).Delete(model).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,217 @@
+package lib
+
+import (
+	"encoding/base64"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page sizes of list endpoints, when the client does not ask for a size and the most it may ask for.
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// ErrInvalidCursor is returned when a pagination cursor was not issued by the API.
+var ErrInvalidCursor = NewError(fiber.StatusBadRequest, "The pagination cursor is invalid.", nil, "INVALID_CURSOR")
+
+// Cursor is a position in a list ordered by creation time, the ID breaks ties between rows created at the same time.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// Encode returns the cursor as an opaque string to hand to clients.
+func (c Cursor) Encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.CreatedAt.UnixMicro(), 10) + ":" + c.ID))
+}
+
+// Newer reports whether the cursor comes before the other in a list ordered newest first.
+func (c Cursor) Newer(other Cursor) bool {
+	if !c.CreatedAt.Equal(other.CreatedAt) {
+		return c.CreatedAt.After(other.CreatedAt)
+	}
+	return c.ID > other.ID
+}
+
+// DecodeCursor parses a cursor previously returned by Encode.
+func DecodeCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	micros, id, ok := strings.Cut(string(raw), ":")
+	if !ok || id == "" {
+		return nil, ErrInvalidCursor
+	}
+
+	t, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &Cursor{CreatedAt: time.UnixMicro(t), ID: id}, nil
+}
+
+// CursorParams holds the ?limit=, ?before= and ?after= parameters of a list request.
+// Lists are ordered newest first, so after moves towards older rows and before towards newer ones.
+type CursorParams struct {
+	Limit  int
+	Before *Cursor
+	After  *Cursor
+}
+
+// parseLimit parses the page size query parameter, falling back to the default.
+func parseLimit(c *fiber.Ctx, name string) (int, error) {
+	raw := c.Query(name)
+	if raw == "" {
+		return DefaultPageSize, nil
+	}
+
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 1 || limit > MaxPageSize {
+		return 0, NewError(fiber.StatusBadRequest, "The page size must be between 1 and "+strconv.Itoa(MaxPageSize)+".", &ErrorDetails{
+			Fields: []ErrorField{
+				{Name: name, Errors: []string{"The page size must be between 1 and " + strconv.Itoa(MaxPageSize) + "."}},
+			},
+		})
+	}
+
+	return limit, nil
+}
+
+// ParseCursorParams parses the cursor pagination parameters of the request.
+func ParseCursorParams(c *fiber.Ctx) (*CursorParams, error) {
+	limit, err := parseLimit(c, "limit")
+	if err != nil {
+		return nil, err
+	}
+
+	params := &CursorParams{Limit: limit}
+
+	if before, after := c.Query("before"), c.Query("after"); before != "" && after != "" {
+		return nil, NewError(fiber.StatusBadRequest, "Only one of before and after may be given.", nil, "INVALID_CURSOR")
+	} else if before != "" {
+		if params.Before, err = DecodeCursor(before); err != nil {
+			return nil, err
+		}
+	} else if after != "" {
+		if params.After, err = DecodeCursor(after); err != nil {
+			return nil, err
+		}
+	}
+
+	return params, nil
+}
+
+// Apply limits the query to the page, ordering it by the creation time and ID columns of the table.
+// One extra row is fetched so CursorPage can tell whether there are more.
+func (p *CursorParams) Apply(query *gorm.DB, table string) *gorm.DB {
+	return p.ApplyColumns(query, table+".created_at", table+".id")
+}
+
+// ApplyColumns limits the query to the page like Apply, for tables whose rows are ordered by other columns.
+func (p *CursorParams) ApplyColumns(query *gorm.DB, createdAt, id string) *gorm.DB {
+	columns := "(" + createdAt + ", " + id + ")"
+
+	switch {
+	case p.Before != nil:
+		// Rows newer than the cursor are fetched oldest first, so the closest ones are kept, and reversed by CursorPage.
+		query = query.Where(columns+" > (?, ?)", p.Before.CreatedAt, p.Before.ID).
+			Order(createdAt + " ASC").Order(id + " ASC")
+	case p.After != nil:
+		query = query.Where(columns+" < (?, ?)", p.After.CreatedAt, p.After.ID).
+			Order(createdAt + " DESC").Order(id + " DESC")
+	default:
+		query = query.Order(createdAt + " DESC").Order(id + " DESC")
+	}
+
+	return query.Limit(p.Limit + 1)
+}
+
+// CursorPage trims the rows fetched with Apply to the page, newest first, and builds the cursors to the pages either side of it.
+func CursorPage[T any](p *CursorParams, rows []T, key func(T) Cursor) ([]T, *Pagination) {
+	more := len(rows) > p.Limit
+	if more {
+		rows = rows[:p.Limit]
+	}
+
+	if p.Before != nil {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+
+	pagination := &Pagination{CursorPagination: &CursorPagination{Limit: p.Limit}}
+	if len(rows) == 0 {
+		return rows, pagination
+	}
+
+	// Coming from a page means there are rows back in that direction.
+	if more || p.Before != nil {
+		pagination.Next = key(rows[len(rows)-1]).Encode()
+	}
+	if (more && p.Before != nil) || p.After != nil {
+		pagination.Prev = key(rows[0]).Encode()
+	}
+
+	return rows, pagination
+}
+
+// PageParams holds the ?page= and ?per_page= parameters of a list paginated by page number.
+// Page numbers are only used where the total is needed, such as admin lists, as they get slower the further in they go.
+type PageParams struct {
+	Page    int
+	PerPage int
+}
+
+// ParsePageParams parses the page number pagination parameters of the request.
+func ParsePageParams(c *fiber.Ctx) (*PageParams, error) {
+	perPage, err := parseLimit(c, "per_page")
+	if err != nil {
+		return nil, err
+	}
+
+	page := c.QueryInt("page", 1)
+	if page < 1 {
+		page = 1
+	}
+
+	return &PageParams{Page: page, PerPage: perPage}, nil
+}
+
+// Paginate counts the rows matching the query and finds those on the page into dest.
+func (p *PageParams) Paginate(query *gorm.DB, dest any) (*Pagination, error) {
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	if err := query.Offset((p.Page - 1) * p.PerPage).Limit(p.PerPage).Find(dest).Error; err != nil {
+		return nil, err
+	}
+
+	lastPage := int((total + int64(p.PerPage) - 1) / int64(p.PerPage))
+	if lastPage < 1 {
+		lastPage = 1
+	}
+
+	pagination := &Pagination{PagePagination: &PagePagination{
+		Page:         p.Page,
+		PerPage:      p.PerPage,
+		LastPage:     lastPage,
+		TotalEntries: int(total),
+	}}
+	if p.Page > 1 {
+		pagination.PreviousPage = p.Page - 1
+	}
+	if p.Page < lastPage {
+		pagination.NextPage = p.Page + 1
+	}
+
+	return pagination, nil
+}
@@ -0,0 +1,111 @@
+package lib
+
+import (
+	"github.com/gofiber/fiber/v2"
+	log "github.com/sirupsen/logrus"
+	"github.com/twibber/api/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Permissions which can be held through roles.
+const (
+	PermPostsDeleteAny   = "posts.delete_any"
+	PermUsersDelete      = "users.delete"
+	PermUsersSuspend     = "users.suspend"
+	PermUsersVerify      = "users.verify"
+	PermUsersImpersonate = "users.impersonate"
+	PermRolesManage      = "roles.manage"
+	PermAuditRead        = "audit.read"
+)
+
+// Predefined roles, created or updated when migrating the database.
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleSupport   = "support"
+)
+
+// DefaultRoles are the roles seeded into the database along with their permissions.
+var DefaultRoles = []models.Role{
+	{
+		BaseModel:   models.BaseModel{ID: RoleAdmin},
+		Description: "Full access to every administrative action.",
+		Permissions: []string{PermPostsDeleteAny, PermUsersDelete, PermUsersSuspend, PermUsersVerify, PermUsersImpersonate, PermRolesManage, PermAuditRead},
+	},
+	{
+		BaseModel:   models.BaseModel{ID: RoleModerator},
+		Description: "Removes posts and suspends users who break the rules.",
+		Permissions: []string{PermPostsDeleteAny, PermUsersSuspend},
+	},
+	{
+		BaseModel:   models.BaseModel{ID: RoleSupport},
+		Description: "Helps users with their accounts and verifies people.",
+		Permissions: []string{PermUsersVerify},
+	},
+}
+
+// ErrMissingPermission is returned when a user does not hold the permission a route requires.
+var ErrMissingPermission = NewError(fiber.StatusForbidden, "You do not have permission to perform this action.", nil, "MISSING_PERMISSION")
+
+// GetPermissions returns every permission the user holds through their roles.
+func GetPermissions(tx *gorm.DB, userID string) ([]string, error) {
+	permissions := make([]string, 0)
+	if err := tx.Model(&models.Role{}).
+		Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+		Where("user_roles.user_id = ?", userID).
+		Distinct().
+		Pluck("unnest(roles.permissions)", &permissions).Error; err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// HasPermission reports whether the user holds the permission through any of their roles.
+func HasPermission(tx *gorm.DB, userID, permission string) (bool, error) {
+	var count int64
+	if err := tx.Model(&models.UserRole{}).
+		Joins("JOIN roles ON roles.id = user_roles.role_id").
+		Where("user_roles.user_id = ? AND ? = ANY(roles.permissions)", userID, permission).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// seedRoles creates the default roles, resetting their permissions to the defaults.
+func seedRoles() error {
+	return DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"description", "permissions", "updated_at"}),
+	}).Create(&DefaultRoles).Error
+}
+
+// migrateAdmins grants the admin role to users flagged with the admin column which roles replaced, then drops it.
+func migrateAdmins() error {
+	if !DB.Migrator().HasColumn(&models.User{}, "admin") {
+		return nil
+	}
+
+	var ids []string
+	if err := DB.Model(&models.User{}).Where("admin = ?", true).Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.UserRole{
+			UserID: id,
+			RoleID: RoleAdmin,
+		}).Error; err != nil {
+			return err
+		}
+	}
+
+	if len(ids) > 0 {
+		log.WithField("users", len(ids)).Info("granted admin role to existing admins")
+	}
+
+	return DB.Migrator().DropColumn(&models.User{}, "admin")
+}
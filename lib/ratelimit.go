@@ -0,0 +1,209 @@
+package lib
+
+import (
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimits is the store holding rate limit buckets, set once the database connection is established.
+var RateLimits RateLimitStore
+
+// ErrRateLimited is returned when a rate limit has been exceeded, the retry delay is set with WithRetryAfter.
+var ErrRateLimited = NewError(fiber.StatusTooManyRequests, "You are making requests too quickly. Please slow down.", nil, "RATE_LIMITED")
+
+// RateLimitPolicy is a token bucket holding Limit tokens which refills completely over Period.
+// Each request takes a token, so bursts of up to Limit requests are allowed.
+type RateLimitPolicy struct {
+	Name   string        // Name of the policy, separating its buckets from those of other policies
+	Limit  int           // Capacity of the bucket
+	Period time.Duration // Time taken for an empty bucket to refill
+}
+
+// Rate returns the number of tokens added to the bucket per second.
+func (p RateLimitPolicy) Rate() float64 {
+	return float64(p.Limit) / p.Period.Seconds()
+}
+
+// String formats the policy for the RateLimit-Policy header, such as "10;w=60".
+func (p RateLimitPolicy) String() string {
+	return fmt.Sprintf("%d;w=%d", p.Limit, int(p.Period.Seconds()))
+}
+
+// RateLimitResult is the state of a bucket after a token was requested from it.
+type RateLimitResult struct {
+	Allowed   bool    // Whether a token was taken
+	Remaining float64 // Tokens left in the bucket
+}
+
+// Reset returns how long the bucket takes to refill completely.
+func (r RateLimitResult) Reset(p RateLimitPolicy) time.Duration {
+	return tokenTime(p, float64(p.Limit)-r.Remaining)
+}
+
+// RetryAfter returns how long until the bucket holds a token again.
+func (r RateLimitResult) RetryAfter(p RateLimitPolicy) time.Duration {
+	return tokenTime(p, 1-r.Remaining)
+}
+
+// tokenTime returns how long the policy takes to add the given number of tokens.
+func tokenTime(p RateLimitPolicy, tokens float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+	return time.Duration(tokens / p.Rate() * float64(time.Second))
+}
+
+// RateLimitStore holds token buckets, implementations must be safe for concurrent use.
+type RateLimitStore interface {
+	// Take refills the bucket of the key according to the policy and takes a token from it if one is available.
+	Take(key string, policy RateLimitPolicy) (RateLimitResult, error)
+}
+
+// NewRateLimitStore returns the store of the given kind, defaulting to memory.
+func NewRateLimitStore(kind string) RateLimitStore {
+	switch kind {
+	case "", "memory":
+		return NewMemoryRateLimitStore()
+	case "postgres":
+		return NewPostgresRateLimitStore(DB)
+	default:
+		log.WithField("store", kind).Fatal("unknown rate limit store")
+		return nil
+	}
+}
+
+// TakeRateLimit takes a token for the key from the policy's bucket.
+func TakeRateLimit(key string, policy RateLimitPolicy) (RateLimitResult, error) {
+	return RateLimits.Take(policy.Name+":"+key, policy)
+}
+
+// MemoryRateLimitStore keeps buckets in memory, suitable when the API runs as a single instance.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]memoryBucket
+	lastSweep time.Time
+}
+
+type memoryBucket struct {
+	tokens     float64
+	refilledAt time.Time
+	fullAt     time.Time // Time the bucket is full again, after which it can be dropped
+}
+
+// NewMemoryRateLimitStore creates an empty in-memory store.
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]memoryBucket)}
+}
+
+func (s *MemoryRateLimitStore) Take(key string, policy RateLimitPolicy) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = memoryBucket{tokens: float64(policy.Limit), refilledAt: now}
+	}
+
+	bucket.tokens = math.Min(float64(policy.Limit), bucket.tokens+now.Sub(bucket.refilledAt).Seconds()*policy.Rate())
+	bucket.refilledAt = now
+
+	result := RateLimitResult{Allowed: bucket.tokens >= 1}
+	if result.Allowed {
+		bucket.tokens--
+	}
+	result.Remaining = bucket.tokens
+
+	bucket.fullAt = now.Add(result.Reset(policy))
+	s.buckets[key] = bucket
+
+	return result, nil
+}
+
+// sweep drops full buckets, which behave the same as missing ones, the lock must be held.
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < memorySweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, bucket := range s.buckets {
+		if bucket.fullAt.Before(now) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// PostgresRateLimitStore keeps buckets in the database so limits are shared between instances.
+type PostgresRateLimitStore struct {
+	db *gorm.DB
+
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+const (
+	rateLimitRetention     = 24 * time.Hour   // How long an unused bucket is kept, longer than any policy's period
+	rateLimitPruneInterval = 10 * time.Minute // How often unused buckets are removed
+)
+
+// NewPostgresRateLimitStore creates a store backed by the rate_limit_buckets table.
+func NewPostgresRateLimitStore(db *gorm.DB) *PostgresRateLimitStore {
+	return &PostgresRateLimitStore{db: db}
+}
+
+// takeTokenQuery refills and takes from a bucket in a single statement so concurrent requests are never lost.
+// The database clock is used so that every instance agrees on the time.
+const takeTokenQuery = `
+INSERT INTO rate_limit_buckets AS b (id, tokens, allowed, refilled_at)
+VALUES (@key, @initial, true, now())
+ON CONFLICT (id) DO UPDATE SET
+	allowed     = LEAST(@limit, b.tokens + EXTRACT(EPOCH FROM now() - b.refilled_at)::float8 * @rate) >= 1,
+	tokens      = LEAST(@limit, b.tokens + EXTRACT(EPOCH FROM now() - b.refilled_at)::float8 * @rate)
+		- CASE WHEN LEAST(@limit, b.tokens + EXTRACT(EPOCH FROM now() - b.refilled_at)::float8 * @rate) >= 1 THEN 1 ELSE 0 END,
+	refilled_at = now()
+RETURNING tokens, allowed`
+
+func (s *PostgresRateLimitStore) Take(key string, policy RateLimitPolicy) (RateLimitResult, error) {
+	s.prune()
+
+	var row struct {
+		Tokens  float64
+		Allowed bool
+	}
+	if err := s.db.Raw(takeTokenQuery, map[string]any{
+		"key":     key,
+		"initial": float64(policy.Limit) - 1, // A new bucket starts full, less the token being taken
+		"limit":   float64(policy.Limit),
+		"rate":    policy.Rate(),
+	}).Scan(&row).Error; err != nil {
+		return RateLimitResult{}, err
+	}
+
+	return RateLimitResult{Allowed: row.Allowed, Remaining: row.Tokens}, nil
+}
+
+// prune removes unused buckets in the background, at most once per prune interval.
+func (s *PostgresRateLimitStore) prune() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.lastPrune) < rateLimitPruneInterval {
+		return
+	}
+	s.lastPrune = time.Now()
+
+	go func() {
+		if err := s.db.Exec("DELETE FROM rate_limit_buckets WHERE refilled_at < ?",
+			time.Now().Add(-rateLimitRetention)).Error; err != nil {
+			log.WithError(err).Warn("could not prune rate limit buckets")
+		}
+	}()
+}
@@ -0,0 +1,36 @@
+package lib
+
+import (
+	"github.com/twibber/api/models"
+	"gorm.io/gorm"
+)
+
+// FollowedUsers returns a subquery selecting the IDs of every user the user follows.
+func FollowedUsers(tx *gorm.DB, userID string) *gorm.DB {
+	return tx.Model(&models.Follow{}).Select("followed_id").Where("user_id = ?", userID)
+}
+
+// HiddenUsers returns a subquery selecting the IDs of every user whose posts the user should not see:
+// those they have muted, those they have blocked and those who have blocked them.
+func HiddenUsers(tx *gorm.DB, userID string) *gorm.DB {
+	return tx.Raw(`SELECT muted_id FROM mutes WHERE user_id = ?
+		UNION SELECT blocked_id FROM blocks WHERE user_id = ?
+		UNION SELECT user_id FROM blocks WHERE blocked_id = ?`, userID, userID, userID)
+}
+
+// MutedConversations returns a subquery selecting the IDs of every conversation the user has muted.
+func MutedConversations(tx *gorm.DB, userID string) *gorm.DB {
+	return tx.Model(&models.ConversationMute{}).Select("conversation_id").Where("user_id = ?", userID)
+}
+
+// IsBlocked reports whether either user has blocked the other.
+func IsBlocked(tx *gorm.DB, userID, otherID string) (bool, error) {
+	var count int64
+	if err := tx.Model(&models.Block{}).
+		Where("(user_id = ? AND blocked_id = ?) OR (user_id = ? AND blocked_id = ?)", userID, otherID, otherID, userID).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
@@ -13,7 +13,22 @@ type Response struct {
 }
 
 // Pagination details the structure for pagination metadata in list responses.
+// Most lists use cursors, while admin lists which need the total use page numbers, and only the block in use is sent.
 type Pagination struct {
+	*CursorPagination
+	*PagePagination
+}
+
+// CursorPagination is the pagination metadata of a list paginated by cursor.
+// The cursors are left out when there is no page in that direction.
+type CursorPagination struct {
+	Limit int    `json:"limit"`          // The maximum number of items per page
+	Next  string `json:"next,omitempty"` // Cursor to pass as ?after= for the next, older, page, if there is one
+	Prev  string `json:"prev,omitempty"` // Cursor to pass as ?before= for the previous, newer, page, if there is one
+}
+
+// PagePagination is the pagination metadata of a list paginated by page number.
+type PagePagination struct {
 	Page         int `json:"page"`          // The current page number
 	PerPage      int `json:"per_page"`      // The number of items per page
 	PreviousPage int `json:"previous_page"` // The previous page number, if applicable
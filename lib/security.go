@@ -4,12 +4,16 @@ import (
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/base32"
+	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/alexedwards/argon2id"
 	log "github.com/sirupsen/logrus"
+	cfg "github.com/twibber/api/config"
 	"runtime"
 	"strings"
 	"time"
@@ -128,6 +132,81 @@ func ValidateTOTP(secret, code string, stepType StepDurationType) bool {
 	return subtleCompare(code, expectedCode)
 }
 
+// ValidateMFACode verifies an MFA code allowing one step of clock skew either side of the current time.
+// It returns the matched time step, which must be newer than lastStep so that a code cannot be reused.
+func ValidateMFACode(secret, code string, lastStep int64) (int64, bool) {
+	current := time.Now().Unix() / stepDurations[MFACode]
+
+	for step := current - 1; step <= current+1; step++ {
+		if step <= lastStep {
+			continue
+		}
+
+		expectedCode, err := ComputeTOTP(secret, step)
+		if err != nil {
+			return 0, false
+		}
+
+		if subtleCompare(code, expectedCode) {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// HashToken returns the hex encoded SHA-256 hash of a token so it can be stored without exposing the token itself.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ErrPasswordResetRequired is returned when a password has been cleared, such as after a reverted email change.
+var ErrPasswordResetRequired = NewError(403, "The password of this account must be reset before it can be used.", nil, "PASSWORD_RESET_REQUIRED")
+
+// MinSecretKeyLength is the length below which the secret key is refused, as values signed with it could be forged.
+const MinSecretKeyLength = 32
+
+// CheckSecretKey stops the application when the secret key is missing or too short.
+func CheckSecretKey() {
+	if len(cfg.Config.SecretKey) < MinSecretKeyLength {
+		log.WithField("length", len(cfg.Config.SecretKey)).
+			Fatalf("SECRET_KEY must be set to at least %d characters", MinSecretKeyLength)
+	}
+}
+
+// SignValue appends an HMAC signature to the value using the configured secret key.
+func SignValue(value string) string {
+	return value + "." + signature(value)
+}
+
+// VerifySignedValue checks the signature of a value produced by SignValue and returns the original value.
+// Nothing is accepted without a usable secret key, as anyone could have produced the signature.
+func VerifySignedValue(signed string) (string, bool) {
+	if len(cfg.Config.SecretKey) < MinSecretKeyLength {
+		return "", false
+	}
+
+	i := strings.LastIndex(signed, ".")
+	if i < 0 {
+		return "", false
+	}
+
+	value, sig := signed[:i], signed[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(signature(value))) {
+		return "", false
+	}
+
+	return value, true
+}
+
+// signature computes the base64 encoded HMAC-SHA256 of a value using the configured secret key.
+func signature(value string) string {
+	mac := hmac.New(sha256.New, []byte(cfg.Config.SecretKey))
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
 // subtleCompare does a constant-time comparison of two strings.
 func subtleCompare(a, b string) bool {
 	if len(a) != len(b) {
@@ -4,44 +4,47 @@ import (
 	"errors"
 	"github.com/gofiber/fiber/v2"
 	"github.com/sirupsen/logrus"
+	cfg "github.com/twibber/api/config"
+	"github.com/twibber/api/mailer"
 	"github.com/twibber/api/models"
 	"gorm.io/gorm"
 	"strings"
 	"time"
 )
 
-// GetSession returns the session from the database using the auth token provided in the request.
-func GetSession(c *fiber.Ctx) *models.Session {
+// GetAuthToken returns the auth token provided in the request's header or cookie, or an empty string.
+func GetAuthToken(c *fiber.Ctx) string {
 	// Extracts auth token from header or cookie
 	authHeader := c.Get("Authorization")
 	authCookie := c.Cookies("Authorization")
 
-	// Rejects request if no auth details are provided
-	if authHeader == "" && authCookie == "" {
-		logrus.Debug("No auth header or cookie")
-		return nil
-	}
-
-	var authToken string
-
 	// Parses auth token from header or uses cookie
 	if authHeader != "" {
 		authParsed := strings.Split(authHeader, " ")
 		if len(authParsed) < 2 {
 			logrus.Debug("Invalid auth header")
-			return nil
+			return ""
 		}
-		authToken = authParsed[1]
-	} else if authCookie != "" {
-		authToken = authCookie
+		return authParsed[1]
 	}
 
-	// Fetches session from database using auth token
+	return authCookie
+}
+
+// GetSession returns the session from the database using the auth token provided in the request.
+func GetSession(c *fiber.Ctx) *models.Session {
+	authToken := GetAuthToken(c)
+
+	// Rejects request if no auth details are provided
+	if authToken == "" {
+		logrus.Debug("No auth header or cookie")
+		return nil
+	}
+
+	// Fetches session from database using the hash of the auth token
 	var session models.Session
 	if err := DB.Where(models.Session{
-		BaseModel: models.BaseModel{
-			ID: authToken,
-		},
+		TokenHash: HashToken(authToken),
 	}).Preload("Connection").Preload("Connection.User").First(&session).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			logrus.Debug("Session not found")
@@ -50,11 +53,243 @@ func GetSession(c *fiber.Ctx) *models.Session {
 		return nil
 	}
 
-	if session.ExpiresAt.Before(time.Now()) {
+	now := time.Now()
+
+	if session.ExpiresAt.Before(now) || (session.MaxExpiresAt != nil && session.MaxExpiresAt.Before(now)) {
 		logrus.Debug("Session expired")
 		DB.Delete(&session)
 		return nil
 	}
 
+	// Active sessions are extended, at most once per renew interval to avoid a write on every request.
+	if session.Info.LastSeenAt == nil || now.Sub(*session.Info.LastSeenAt) >= cfg.Config.SessionRenewInterval {
+		renewSession(c, &session, authToken, now)
+	}
+
 	return &session
 }
+
+// Authenticate returns the session the request was made with, clearing the auth cookie when there is none
+// and rejecting suspended users.
+func Authenticate(c *fiber.Ctx) (*models.Session, error) {
+	session := GetSession(c)
+	if session == nil {
+		ClearAuth(c)
+		return nil, ErrUnauthorised
+	}
+
+	if err := CheckSuspended(session.Connection.User); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// renewSession extends the idle expiry of the session, without going past its absolute expiry,
+// and refreshes the cookie of remembered sessions so it does not expire before the session.
+func renewSession(c *fiber.Ctx, session *models.Session, authToken string, now time.Time) {
+	idle, _ := SessionLifetimes(session.RememberMe)
+
+	expiresAt := now.Add(idle)
+	if session.MaxExpiresAt != nil && session.MaxExpiresAt.Before(expiresAt) {
+		expiresAt = *session.MaxExpiresAt
+	}
+
+	if err := DB.Model(session).Updates(map[string]any{
+		"expires_at":        expiresAt,
+		"info_last_seen_at": now,
+		"info_last_ip":      c.IP(),
+	}).Error; err != nil {
+		logrus.WithError(err).Error("session could not be renewed")
+		return
+	}
+
+	session.ExpiresAt = expiresAt
+	session.Info.LastSeenAt = &now
+	session.Info.LastIP = c.IP()
+
+	if session.RememberMe && c.Cookies("Authorization") == authToken {
+		SetAuth(c, authToken, expiresAt.Sub(now))
+	}
+}
+
+// SessionLifetimes returns the idle and absolute lifetimes of a session.
+func SessionLifetimes(rememberMe bool) (idle, absolute time.Duration) {
+	if rememberMe {
+		return cfg.Config.SessionRememberIdleLifetime, cfg.Config.SessionRememberAbsoluteLifetime
+	}
+	return cfg.Config.SessionIdleLifetime, cfg.Config.SessionAbsoluteLifetime
+}
+
+// NewSession builds a session for the token and returns it along with the max age of its cookie.
+// Sessions which are not remembered use a browser session cookie, which is cleared when the browser is closed.
+func NewSession(c *fiber.Ctx, token string, rememberMe bool) (models.Session, time.Duration) {
+	now := time.Now()
+	idle, absolute := SessionLifetimes(rememberMe)
+	maxExpiresAt := now.Add(absolute)
+
+	// Only the hash of the token is stored, the session ID is a separate public identifier.
+	session := models.Session{
+		TokenHash: HashToken(token),
+		Info: models.SessionInfo{
+			IPAddresses: c.IPs(),
+			UserAgent:   c.Get("User-Agent"),
+			LastSeenAt:  &now,
+			LastIP:      c.IP(),
+		},
+		RememberMe:   rememberMe,
+		ExpiresAt:    now.Add(idle),
+		MaxExpiresAt: &maxExpiresAt,
+	}
+	ParseUserAgent(&session.Info)
+
+	if !rememberMe {
+		return session, 0
+	}
+	return session, idle
+}
+
+// CreateSession creates a new session for the connection and returns it as a login.
+// Suspended users are refused, and when the session comes from a device the connection has not logged in from before,
+// the login holds a notice which must be sent with Notify once the transaction has been committed.
+func CreateSession(tx *gorm.DB, c *fiber.Ctx, connectionID string, rememberMe bool) (*Login, error) {
+	var user models.User
+	if err := tx.Where("id = (?)", tx.Model(&models.Connection{}).Select("user_id").Where("id = ?", connectionID)).
+		First(&user).Error; err != nil {
+		return nil, err
+	}
+
+	if err := CheckSuspended(&user); err != nil {
+		return nil, err
+	}
+
+	token := GenerateString(64)
+
+	session, exp := NewSession(c, token, rememberMe)
+	session.ConnectionID = connectionID
+
+	if err := tx.Create(&session).Error; err != nil {
+		return nil, err
+	}
+
+	login := &Login{Token: token, MaxAge: exp}
+
+	fingerprint, isNew, err := TrackDevice(tx, &session)
+	if err != nil {
+		return nil, err
+	}
+
+	if isNew {
+		if login.Notice, err = NewSignInNotice(tx, &session, fingerprint); err != nil {
+			return nil, err
+		}
+	}
+
+	return login, nil
+}
+
+// Login is the outcome of a successful first factor, either a session or an MFA challenge which must be answered first.
+type Login struct {
+	Challenge string               // Token of the MFA challenge, when the account has MFA enabled
+	Token     string               // Token of the new session, when it does not
+	MaxAge    time.Duration        // Max age of the session's cookie
+	Notice    *mailer.NewSignInDTO // Email about a sign-in from a new device, if the session is from one
+}
+
+// Notify concurrently sends the new sign-in notice of the login, if it has one.
+// It must only be called once the transaction the session was created in has been committed.
+func (l *Login) Notify() {
+	if l.Notice == nil {
+		return
+	}
+
+	notice := *l.Notice
+	go func() {
+		if err := notice.Send(); err != nil {
+			logrus.WithError(err).Error("new sign-in notification could not be sent")
+		}
+	}()
+}
+
+// CompleteLogin issues a session for the connection, or an MFA challenge if the account has MFA enabled.
+// Every way of logging in goes through here so none of them skip the second factor,
+// and suspended accounts are refused before any MFA challenge is issued.
+func CompleteLogin(tx *gorm.DB, c *fiber.Ctx, connectionID string, rememberMe bool) (*Login, error) {
+	var user models.User
+	if err := tx.Where("id = (?)", tx.Model(&models.Connection{}).Select("user_id").Where("id = ?", connectionID)).
+		First(&user).Error; err != nil {
+		return nil, err
+	}
+
+	if err := CheckSuspended(&user); err != nil {
+		return nil, err
+	}
+
+	if user.MFA != "" {
+		challenge, err := CreateMFAChallenge(tx, connectionID, rememberMe)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Login{Challenge: challenge}, nil
+	}
+
+	return CreateSession(tx, c, connectionID, rememberMe)
+}
+
+// SendLogin responds with the MFA challenge the client must answer, or sets the cookie of the new session
+// and sends its new sign-in notice. It must only be called once the transaction has been committed.
+func SendLogin(c *fiber.Ctx, login *Login) error {
+	if login.Challenge != "" {
+		return c.Status(fiber.StatusAccepted).JSON(Response{
+			Success: true,
+			Data: MFAChallengeResponse{
+				MFARequired: true,
+				Challenge:   login.Challenge,
+			},
+		})
+	}
+
+	// Set a cookie with the authorization token.
+	SetAuth(c, login.Token, login.MaxAge)
+	login.Notify()
+
+	return c.Status(fiber.StatusOK).JSON(BlankSuccess)
+}
+
+// UserConnections returns a subquery selecting the IDs of every connection belonging to the user.
+func UserConnections(tx *gorm.DB, userID string) *gorm.DB {
+	return tx.Model(&models.Connection{}).Select("id").Where("user_id = ?", userID)
+}
+
+// RevokeSessions deletes every session across the user's connections, except the session with the given ID if one is provided,
+// and returns how many were revoked.
+func RevokeSessions(tx *gorm.DB, userID, keepSessionID string) (int64, error) {
+	query := tx.Where("connection_id IN (?)", UserConnections(tx, userID))
+	if keepSessionID != "" {
+		query = query.Where("id <> ?", keepSessionID)
+	}
+
+	result := query.Delete(&models.Session{})
+	return result.RowsAffected, result.Error
+}
+
+// RevokeAPIAccess deletes every API token of the user, both personal tokens and those issued to OAuth clients,
+// along with OAuth refresh tokens and unexchanged codes, and returns how many tokens were revoked.
+// Clients keep the user's consent, so they only need the user to sign in again.
+func RevokeAPIAccess(tx *gorm.DB, userID string) (int64, error) {
+	var revoked int64
+	for _, model := range []any{&models.APIToken{}, &models.OAuthRefreshToken{}} {
+		result := tx.Where("user_id = ?", userID).Delete(model)
+		if result.Error != nil {
+			return 0, result.Error
+		}
+		revoked += result.RowsAffected
+	}
+
+	if err := tx.Where("user_id = ?", userID).Delete(&models.OAuthCode{}).Error; err != nil {
+		return 0, err
+	}
+
+	return revoked, nil
+}
@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/twibber/api/models"
+	"gorm.io/gorm"
+	"time"
+)
+
+// SuspensionDetails tells a suspended user why and until when their account is suspended.
+type SuspensionDetails struct {
+	Reason string     `json:"reason,omitempty"`
+	Until  *time.Time `json:"until,omitempty"` // Never ends if empty
+}
+
+// ErrCannotSuspend is returned when attempting to suspend a staff member.
+var ErrCannotSuspend = NewError(fiber.StatusForbidden, "Staff members cannot be suspended.", nil, "CANNOT_SUSPEND")
+
+// IsSuspended reports whether the user is suspended, suspensions which have ended are ignored.
+func IsSuspended(user *models.User) bool {
+	return user.Suspended && (user.SuspendedUntil == nil || user.SuspendedUntil.After(time.Now()))
+}
+
+// SuspensionError returns the error refusing the suspended user, including the reason and end of their suspension.
+func SuspensionError(user *models.User) Error {
+	return NewError(fiber.StatusForbidden, "Your account has been suspended.", &ErrorDetails{
+		Suspension: &SuspensionDetails{
+			Reason: user.SuspensionReason,
+			Until:  user.SuspendedUntil,
+		},
+	}, "SUSPENDED")
+}
+
+// CheckSuspended returns a suspension error if the user is suspended.
+func CheckSuspended(user *models.User) error {
+	if user != nil && IsSuspended(user) {
+		return SuspensionError(user)
+	}
+	return nil
+}
+
+// SuspendedUsers returns a subquery selecting the IDs of every user who is currently suspended.
+func SuspendedUsers(tx *gorm.DB) *gorm.DB {
+	return tx.Model(&models.User{}).Select("id").
+		Where("suspended AND (suspended_until IS NULL OR suspended_until > ?)", time.Now())
+}
+
+// IsStaff reports whether the user has been granted any role.
+func IsStaff(tx *gorm.DB, userID string) (bool, error) {
+	var roles int64
+	if err := tx.Model(&models.UserRole{}).Where(models.UserRole{UserID: userID}).Count(&roles).Error; err != nil {
+		return false, err
+	}
+	return roles > 0, nil
+}
+
+// Suspend suspends the user until the given time, or indefinitely, recording the suspension in the audit log.
+func Suspend(tx *gorm.DB, c *fiber.Ctx, actorID string, user *models.User, reason string, until *time.Time) error {
+	staff, err := IsStaff(tx, user.ID)
+	if err != nil {
+		return err
+	}
+	if staff {
+		return ErrCannotSuspend
+	}
+
+	if err := tx.Model(user).Updates(map[string]any{
+		"suspended":         true,
+		"suspension_reason": reason,
+		"suspended_until":   until,
+	}).Error; err != nil {
+		return err
+	}
+
+	return tx.Create(&models.AuditLog{
+		ActorID:   actorID,
+		SubjectID: &user.ID,
+		Action:    models.AuditSuspend,
+		IP:        c.IP(),
+		Details:   reason,
+	}).Error
+}
+
+// Unsuspend lifts the user's suspension, recording it in the audit log.
+func Unsuspend(tx *gorm.DB, c *fiber.Ctx, actorID string, user *models.User) error {
+	if err := tx.Model(user).Updates(map[string]any{
+		"suspended":         false,
+		"suspension_reason": "",
+		"suspended_until":   nil,
+	}).Error; err != nil {
+		return err
+	}
+
+	return tx.Create(&models.AuditLog{
+		ActorID:   actorID,
+		SubjectID: &user.ID,
+		Action:    models.AuditUnsuspend,
+		IP:        c.IP(),
+	}).Error
+}
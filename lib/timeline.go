@@ -0,0 +1,647 @@
+package lib
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/twibber/api/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Timelines is the store holding materialized home timelines, set once the database connection is established.
+// It is nil when timelines are built from the follow graph on every read instead.
+var Timelines TimelineStore
+
+const (
+	timelineLength         = 800             // Most recent entries kept per timeline by the memory store, and delivered when building one
+	timelineBackfill       = 200             // Most recent posts delivered to a timeline when its user follows someone
+	timelineFanOutBatch    = 1000            // Followers delivered to per statement
+	timelineWorkers        = 4               // Workers processing timeline jobs
+	timelineQueueSize      = 256             // Jobs each worker can have waiting
+	timelineAuthorsRefresh = 5 * time.Minute // How often the authors with too many followers to fan out to are found
+)
+
+// TimelineFanOutLimit is the number of followers above which an author's posts are no longer delivered to each follower's
+// timeline, but merged in when timelines are read, so a single post does not turn into a huge number of writes.
+const TimelineFanOutLimit = 10000
+
+// TimelineItem is a post on a home timeline.
+type TimelineItem struct {
+	PostID     string    // Post or repost shown
+	OriginalID string    // Post being shown, the parent of a repost, only its most recent appearance is shown
+	AuthorID   string    // User who made the post or repost
+	PostedAt   time.Time // Time the post or repost was made
+}
+
+// NewTimelineItem returns the item showing the post on a timeline.
+func NewTimelineItem(post models.Post) TimelineItem {
+	original := post.ID
+	if post.Type == models.PostTypeRepost && post.ParentID != nil {
+		original = *post.ParentID
+	}
+
+	return TimelineItem{
+		PostID:     post.ID,
+		OriginalID: original,
+		AuthorID:   post.UserID,
+		PostedAt:   post.CreatedAt,
+	}
+}
+
+// Cursor returns the position of the item on a timeline, which is the same as that of its post.
+func (i TimelineItem) Cursor() Cursor {
+	return Cursor{CreatedAt: i.PostedAt, ID: i.PostID}
+}
+
+// TimelineStore holds materialized home timelines, implementations must be safe for concurrent use.
+// Every post and repost delivered is kept, so removing one appearance of an original leaves the others in place.
+// Mutes, blocks, suspensions and the follow graph are not applied by the store, but when the posts are loaded.
+type TimelineStore interface {
+	// Add delivers the items to the timeline of each user, items already on a timeline are left as they are.
+	Add(userIDs []string, items ...TimelineItem) error
+	// RemoveAuthor removes the author's posts and reposts from the user's timeline.
+	RemoveAuthor(userID, authorID string) error
+	// RemovePost removes the post, and reposts of it, from every timeline.
+	RemovePost(postID string) error
+	// Page returns the items on the page of the user's timeline, fetched in the order CursorParams.Apply would.
+	// Only the most recent appearance of each original is included.
+	Page(userID string, params *CursorParams) ([]TimelineItem, error)
+	// Built reports whether the user's timeline has been built from the follow graph.
+	Built(userID string) (bool, error)
+	// MarkBuilt records that the user's timeline has been built from the follow graph.
+	MarkBuilt(userID string) error
+}
+
+// NewTimelineStore returns the store of the given kind, defaulting to postgres, or nil for "none".
+func NewTimelineStore(kind string) TimelineStore {
+	switch kind {
+	case "", "postgres":
+		return NewPostgresTimelineStore(DB)
+	case "memory":
+		return NewMemoryTimelineStore()
+	case "none":
+		return nil
+	default:
+		log.WithField("store", kind).Fatal("unknown timeline store")
+		return nil
+	}
+}
+
+// dedupeTimeline keeps the most recent appearance of each original, the items must be ordered newest first.
+func dedupeTimeline(items []TimelineItem) []TimelineItem {
+	seen := make(map[string]bool, len(items))
+	deduped := items[:0]
+	for _, item := range items {
+		if !seen[item.OriginalID] {
+			seen[item.OriginalID] = true
+			deduped = append(deduped, item)
+		}
+	}
+	return deduped
+}
+
+// sortTimeline orders the items newest first.
+func sortTimeline(items []TimelineItem) {
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Cursor().Newer(items[j].Cursor())
+	})
+}
+
+// reverseTimeline reverses the order of the items in place.
+func reverseTimeline(items []TimelineItem) {
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+}
+
+// MemoryTimelineStore keeps timelines in memory, suitable when the API runs as a single instance.
+// Timelines are lost on restart and rebuilt when next read, and only the most recent entries of each are kept.
+type MemoryTimelineStore struct {
+	mu        sync.RWMutex
+	timelines map[string][]TimelineItem // Newest first, with every appearance of an original
+	built     map[string]bool           // Users whose timelines have been built
+}
+
+// NewMemoryTimelineStore creates an empty in-memory store.
+func NewMemoryTimelineStore() *MemoryTimelineStore {
+	return &MemoryTimelineStore{
+		timelines: make(map[string][]TimelineItem),
+		built:     make(map[string]bool),
+	}
+}
+
+func (s *MemoryTimelineStore) Add(userIDs []string, items ...TimelineItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, userID := range userIDs {
+		timeline := s.timelines[userID]
+		for _, item := range items {
+			timeline = insertTimelineItem(timeline, item)
+		}
+
+		if len(timeline) > timelineLength {
+			timeline = timeline[:timelineLength]
+		}
+		s.timelines[userID] = timeline
+	}
+
+	return nil
+}
+
+// insertTimelineItem adds the item to the timeline in order, unless it is already there.
+func insertTimelineItem(timeline []TimelineItem, item TimelineItem) []TimelineItem {
+	i := sort.Search(len(timeline), func(i int) bool {
+		return !timeline[i].Cursor().Newer(item.Cursor())
+	})
+	if i < len(timeline) && timeline[i].PostID == item.PostID {
+		return timeline
+	}
+
+	timeline = append(timeline, TimelineItem{})
+	copy(timeline[i+1:], timeline[i:])
+	timeline[i] = item
+
+	return timeline
+}
+
+func (s *MemoryTimelineStore) RemoveAuthor(userID, authorID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	timeline := s.timelines[userID]
+	kept := timeline[:0]
+	for _, item := range timeline {
+		if item.AuthorID != authorID {
+			kept = append(kept, item)
+		}
+	}
+	s.timelines[userID] = kept
+
+	return nil
+}
+
+func (s *MemoryTimelineStore) RemovePost(postID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for userID, timeline := range s.timelines {
+		kept := timeline[:0]
+		for _, item := range timeline {
+			if item.PostID != postID && item.OriginalID != postID {
+				kept = append(kept, item)
+			}
+		}
+		s.timelines[userID] = kept
+	}
+
+	return nil
+}
+
+func (s *MemoryTimelineStore) Page(userID string, params *CursorParams) ([]TimelineItem, error) {
+	s.mu.RLock()
+	timeline := dedupeTimeline(append([]TimelineItem(nil), s.timelines[userID]...))
+	s.mu.RUnlock()
+
+	size := params.Limit + 1
+
+	var page []TimelineItem
+	switch {
+	case params.Before != nil:
+		// Items newer than the cursor, closest first
+		end := sort.Search(len(timeline), func(i int) bool {
+			return !timeline[i].Cursor().Newer(*params.Before)
+		})
+		start := end - size
+		if start < 0 {
+			start = 0
+		}
+		page = append(page, timeline[start:end]...)
+		reverseTimeline(page)
+	case params.After != nil:
+		start := sort.Search(len(timeline), func(i int) bool {
+			return params.After.Newer(timeline[i].Cursor())
+		})
+		end := start + size
+		if end > len(timeline) {
+			end = len(timeline)
+		}
+		page = append(page, timeline[start:end]...)
+	default:
+		end := size
+		if end > len(timeline) {
+			end = len(timeline)
+		}
+		page = append(page, timeline[:end]...)
+	}
+
+	return page, nil
+}
+
+func (s *MemoryTimelineStore) Built(userID string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.built[userID], nil
+}
+
+func (s *MemoryTimelineStore) MarkBuilt(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.built[userID] = true
+
+	return nil
+}
+
+// PostgresTimelineStore keeps timelines in the timeline_entries table so they are shared between instances.
+type PostgresTimelineStore struct {
+	db *gorm.DB
+}
+
+// NewPostgresTimelineStore creates a store backed by the timeline_entries table.
+func NewPostgresTimelineStore(db *gorm.DB) *PostgresTimelineStore {
+	return &PostgresTimelineStore{db: db}
+}
+
+func (s *PostgresTimelineStore) Add(userIDs []string, items ...TimelineItem) error {
+	entries := make([]models.TimelineEntry, 0, len(userIDs)*len(items))
+	for _, userID := range userIDs {
+		for _, item := range items {
+			entries = append(entries, models.TimelineEntry{
+				UserID:     userID,
+				OriginalID: item.OriginalID,
+				PostID:     item.PostID,
+				AuthorID:   item.AuthorID,
+				PostedAt:   item.PostedAt,
+			})
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	return s.db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&entries, timelineFanOutBatch).Error
+}
+
+func (s *PostgresTimelineStore) RemoveAuthor(userID, authorID string) error {
+	return s.db.Where(models.TimelineEntry{UserID: userID, AuthorID: authorID}).Delete(&models.TimelineEntry{}).Error
+}
+
+// RemovePost deletes the entries of reposts of the post as well as its own, which its foreign key would delete anyway,
+// as reposts are kept with their parent cleared when the original is deleted.
+func (s *PostgresTimelineStore) RemovePost(postID string) error {
+	return s.db.Where("post_id = ? OR original_id = ?", postID, postID).Delete(&models.TimelineEntry{}).Error
+}
+
+func (s *PostgresTimelineStore) Page(userID string, params *CursorParams) ([]TimelineItem, error) {
+	var entries []models.TimelineEntry
+	if err := params.ApplyColumns(s.db.Where(models.TimelineEntry{
+		UserID: userID,
+	}).Where(`NOT EXISTS (SELECT 1 FROM timeline_entries AS newer
+		WHERE newer.user_id = timeline_entries.user_id AND newer.original_id = timeline_entries.original_id
+		AND (newer.posted_at, newer.post_id) > (timeline_entries.posted_at, timeline_entries.post_id))`),
+		"timeline_entries.posted_at", "timeline_entries.post_id").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+
+	items := make([]TimelineItem, len(entries))
+	for i, entry := range entries {
+		items[i] = TimelineItem{
+			PostID:     entry.PostID,
+			OriginalID: entry.OriginalID,
+			AuthorID:   entry.AuthorID,
+			PostedAt:   entry.PostedAt,
+		}
+	}
+
+	return items, nil
+}
+
+func (s *PostgresTimelineStore) Built(userID string) (bool, error) {
+	var built int64
+	if err := s.db.Model(&models.TimelineBuild{}).Where("user_id = ?", userID).Count(&built).Error; err != nil {
+		return false, err
+	}
+
+	return built > 0, nil
+}
+
+func (s *PostgresTimelineStore) MarkBuilt(userID string) error {
+	return s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.TimelineBuild{
+		UserID:  userID,
+		BuiltAt: time.Now(),
+	}).Error
+}
+
+// highFollowerAuthors caches the authors with too many followers to fan out to.
+var highFollowerAuthors struct {
+	sync.Mutex
+	ids         []string
+	refreshedAt time.Time
+}
+
+// HighFollowerAuthors returns the IDs of the authors whose posts are merged into timelines when read.
+func HighFollowerAuthors() ([]string, error) {
+	highFollowerAuthors.Lock()
+	defer highFollowerAuthors.Unlock()
+
+	if time.Since(highFollowerAuthors.refreshedAt) < timelineAuthorsRefresh {
+		return highFollowerAuthors.ids, nil
+	}
+
+	var ids []string
+	if err := DB.Model(&models.Follow{}).
+		Group("followed_id").
+		Having("COUNT(*) > ?", TimelineFanOutLimit).
+		Pluck("followed_id", &ids).Error; err != nil {
+		return nil, err
+	}
+
+	highFollowerAuthors.ids = ids
+	highFollowerAuthors.refreshedAt = time.Now()
+
+	return ids, nil
+}
+
+// isHighFollowerAuthor reports whether the author has too many followers to fan out to.
+func isHighFollowerAuthor(authorID string) (bool, error) {
+	var followers int64
+	if err := DB.Model(&models.Follow{}).Where("followed_id = ?", authorID).Count(&followers).Error; err != nil {
+		return false, err
+	}
+
+	return followers > TimelineFanOutLimit, nil
+}
+
+// timelinePosts returns the most recent posts and reposts matching the query as timeline items.
+func timelinePosts(query *gorm.DB, limit int) ([]TimelineItem, error) {
+	var posts []models.Post
+	if err := query.
+		Where("type = ? OR type = ?", models.PostTypePost, models.PostTypeRepost).
+		Order("created_at DESC").Order("id DESC").
+		Limit(limit).
+		Find(&posts).Error; err != nil {
+		return nil, err
+	}
+
+	items := make([]TimelineItem, len(posts))
+	for i, post := range posts {
+		items[i] = NewTimelineItem(post)
+	}
+
+	return items, nil
+}
+
+// fanOutPost delivers the item to its author's timeline and, unless they have too many, to their followers' timelines.
+func fanOutPost(item TimelineItem) error {
+	if err := Timelines.Add([]string{item.AuthorID}, item); err != nil {
+		return err
+	}
+
+	high, err := isHighFollowerAuthor(item.AuthorID)
+	if err != nil || high {
+		return err
+	}
+
+	// Followers are delivered to in batches, walking them in order of ID.
+	last := ""
+	for {
+		var followers []string
+		if err := DB.Model(&models.Follow{}).
+			Where("followed_id = ? AND user_id > ?", item.AuthorID, last).
+			Order("user_id").
+			Limit(timelineFanOutBatch).
+			Pluck("user_id", &followers).Error; err != nil {
+			return err
+		}
+
+		if len(followers) == 0 {
+			return nil
+		}
+
+		if err := Timelines.Add(followers, item); err != nil {
+			return err
+		}
+
+		if len(followers) < timelineFanOutBatch {
+			return nil
+		}
+		last = followers[len(followers)-1]
+	}
+}
+
+// backfillTimeline delivers the most recent posts of a newly followed author to the user's timeline.
+func backfillTimeline(userID, authorID string) error {
+	high, err := isHighFollowerAuthor(authorID)
+	if err != nil || high {
+		return err
+	}
+
+	items, err := timelinePosts(DB.Where("user_id = ?", authorID), timelineBackfill)
+	if err != nil {
+		return err
+	}
+
+	return Timelines.Add([]string{userID}, items...)
+}
+
+// BuildTimeline delivers the most recent posts of the user and everyone they follow to the user's timeline,
+// and marks it as built so it is only kept up to date from then on.
+func BuildTimeline(userID string) error {
+	items, err := timelinePosts(DB.Where("user_id = ? OR user_id IN (?)", userID, FollowedUsers(DB, userID)), timelineLength)
+	if err != nil {
+		return err
+	}
+
+	if err := Timelines.Add([]string{userID}, items...); err != nil {
+		return err
+	}
+
+	return Timelines.MarkBuilt(userID)
+}
+
+// HomeTimeline returns the items on the page of the user's materialized timeline, fetched in the order CursorParams.Apply would.
+// Posts by followed authors with too many followers to fan out to are merged in from the posts table.
+func HomeTimeline(userID string, params *CursorParams) ([]TimelineItem, error) {
+	// Timelines are built when their first page is first read, such as for users from before timelines were materialized.
+	if params.Before == nil && params.After == nil {
+		built, err := Timelines.Built(userID)
+		if err != nil {
+			return nil, err
+		}
+
+		if !built {
+			if err := BuildTimeline(userID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	items, err := Timelines.Page(userID, params)
+	if err != nil {
+		return nil, err
+	}
+
+	authors, err := HighFollowerAuthors()
+	if err != nil || len(authors) == 0 {
+		return items, err
+	}
+
+	var posts []models.Post
+	if err := params.Apply(DB.Model(&models.Post{}).
+		Where("posts.user_id IN ? AND posts.user_id IN (?)", authors, FollowedUsers(DB, userID)).
+		Where("posts.type = ? OR posts.type = ?", models.PostTypePost, models.PostTypeRepost), "posts").
+		Find(&posts).Error; err != nil {
+		return nil, err
+	}
+
+	if len(posts) == 0 {
+		return items, nil
+	}
+
+	for _, post := range posts {
+		items = append(items, NewTimelineItem(post))
+	}
+
+	// Both sources are ordered the same way, so merged they are sorted, deduplicated and trimmed back to one page.
+	sortTimeline(items)
+	items = dedupeTimeline(items)
+	if params.Before != nil {
+		reverseTimeline(items)
+	}
+	if len(items) > params.Limit+1 {
+		items = items[:params.Limit+1]
+	}
+
+	return items, nil
+}
+
+// RemoveUnfollowed drops the items by authors the user no longer follows, and queues removing them from the timeline again.
+// Unfollowing queues their removal, but a post of theirs being delivered at the same time can put entries back.
+func RemoveUnfollowed(userID string, items []TimelineItem) ([]TimelineItem, error) {
+	authors := make([]string, 0, len(items))
+	for _, item := range items {
+		if item.AuthorID != userID {
+			authors = append(authors, item.AuthorID)
+		}
+	}
+
+	if len(authors) == 0 {
+		return items, nil
+	}
+
+	var followed []string
+	if err := DB.Model(&models.Follow{}).
+		Where("user_id = ? AND followed_id IN ?", userID, authors).
+		Pluck("followed_id", &followed).Error; err != nil {
+		return nil, err
+	}
+
+	following := make(map[string]bool, len(followed))
+	for _, id := range followed {
+		following[id] = true
+	}
+
+	pruned := make(map[string]bool)
+	kept := items[:0]
+	for _, item := range items {
+		if item.AuthorID == userID || following[item.AuthorID] {
+			kept = append(kept, item)
+		} else if !pruned[item.AuthorID] {
+			pruned[item.AuthorID] = true
+			PruneTimeline(userID, item.AuthorID)
+		}
+	}
+
+	return kept, nil
+}
+
+// timelineJob is work keeping materialized timelines up to date.
+type timelineJob struct {
+	name string
+	run  func() error
+}
+
+// timelineQueues hold the jobs of each worker. Jobs with the same key go to the same worker and run in the order queued,
+// jobs with different keys may run in any order, so timelines are checked against the follow graph when read.
+var timelineQueues []chan timelineJob
+
+// StartTimelineWorkers starts the workers keeping materialized timelines up to date, if timelines are materialized.
+func StartTimelineWorkers() {
+	if Timelines == nil {
+		return
+	}
+
+	timelineQueues = make([]chan timelineJob, timelineWorkers)
+	for i := range timelineQueues {
+		queue := make(chan timelineJob, timelineQueueSize)
+		timelineQueues[i] = queue
+
+		go func() {
+			for job := range queue {
+				runTimelineJob(job)
+			}
+		}()
+	}
+}
+
+// runTimelineJob runs the job, logging rather than returning its error as nothing is waiting on it.
+func runTimelineJob(job timelineJob) {
+	if err := job.run(); err != nil {
+		log.WithError(err).WithField("job", job.name).Error("timeline job failed")
+	}
+}
+
+// enqueueTimeline queues the job on the worker for the key, doing nothing if timelines are not materialized.
+func enqueueTimeline(key, name string, run func() error) {
+	if Timelines == nil || len(timelineQueues) == 0 {
+		return
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	queue := timelineQueues[hash.Sum32()%uint32(len(timelineQueues))]
+
+	job := timelineJob{name: name, run: run}
+	select {
+	case queue <- job:
+	default:
+		// Jobs are not dropped or run out of order, so when the worker is behind the request waits for room.
+		log.WithField("job", name).Warn("timeline queue is full")
+		queue <- job
+	}
+}
+
+// FanOutPost delivers a new post or repost to the timelines of its author and their followers in the background.
+func FanOutPost(post models.Post) {
+	item := NewTimelineItem(post)
+	enqueueTimeline(post.UserID, "fan out post", func() error {
+		return fanOutPost(item)
+	})
+}
+
+// BackfillTimeline delivers the recent posts of an author the user has followed to their timeline in the background.
+func BackfillTimeline(userID, authorID string) {
+	enqueueTimeline(userID, "backfill timeline", func() error {
+		return backfillTimeline(userID, authorID)
+	})
+}
+
+// PruneTimeline removes the posts of an author the user has unfollowed from their timeline in the background.
+func PruneTimeline(userID, authorID string) {
+	enqueueTimeline(userID, "prune timeline", func() error {
+		return Timelines.RemoveAuthor(userID, authorID)
+	})
+}
+
+// RemoveFromTimelines removes a deleted post from every timeline in the background.
+func RemoveFromTimelines(post models.Post) {
+	enqueueTimeline(post.UserID, "remove post", func() error {
+		return Timelines.RemovePost(post.ID)
+	})
+}
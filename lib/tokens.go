@@ -0,0 +1,70 @@
+package lib
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/twibber/api/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// ErrInvalidToken is returned when a one-time token is unknown, expired or has already been used.
+var ErrInvalidToken = NewError(fiber.StatusBadRequest, "This link is invalid or has expired.", &ErrorDetails{
+	Fields: []ErrorField{
+		{Name: "token", Errors: []string{"This link is invalid or has expired."}},
+	},
+}, "INVALID_TOKEN")
+
+// IssueToken creates a one-time token for the connection and returns it, only its hash is stored.
+func IssueToken(tx *gorm.DB, purpose models.TokenPurpose, connectionID, data string, ttl time.Duration) (string, error) {
+	token := GenerateString(64)
+
+	if err := tx.Create(&models.OneTimeToken{
+		BaseModel: models.BaseModel{
+			ID: HashToken(token),
+		},
+		Purpose:      purpose,
+		ConnectionID: connectionID,
+		Data:         data,
+		ExpiresAt:    time.Now().Add(ttl),
+	}).Error; err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ConsumeToken marks a one-time token as used and returns it, failing if it cannot be used.
+func ConsumeToken(tx *gorm.DB, purpose models.TokenPurpose, token string) (*models.OneTimeToken, error) {
+	var oneTimeToken models.OneTimeToken
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where(models.OneTimeToken{
+		BaseModel: models.BaseModel{
+			ID: HashToken(token),
+		},
+		Purpose: purpose,
+	}).Preload("Connection").Preload("Connection.User").First(&oneTimeToken).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	if oneTimeToken.UsedAt != nil || oneTimeToken.ExpiresAt.Before(time.Now()) {
+		return nil, ErrInvalidToken
+	}
+
+	now := time.Now()
+	if err := tx.Model(&oneTimeToken).Update("used_at", now).Error; err != nil {
+		return nil, err
+	}
+	oneTimeToken.UsedAt = &now
+
+	return &oneTimeToken, nil
+}
+
+// RevokeTokens deletes the connection's unused tokens for the purpose, such as older reset links.
+func RevokeTokens(tx *gorm.DB, purpose models.TokenPurpose, connectionID string) error {
+	return tx.Where("purpose = ? AND connection_id = ? AND used_at IS NULL", purpose, connectionID).
+		Delete(&models.OneTimeToken{}).Error
+}
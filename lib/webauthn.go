@@ -0,0 +1,219 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofiber/fiber/v2"
+	cfg "github.com/twibber/api/config"
+	"github.com/twibber/api/models"
+	"gorm.io/gorm"
+	"time"
+)
+
+// WebAuthnCeremonyDuration is how long a passkey registration or login has to be completed.
+const WebAuthnCeremonyDuration = 5 * time.Minute
+
+// ErrInvalidPasskey is returned when a passkey ceremony cannot be completed.
+var ErrInvalidPasskey = NewError(fiber.StatusBadRequest, "The passkey could not be verified. Please try again.", nil, "INVALID_PASSKEY")
+
+// WebAuthnUser adapts a user and their passkeys to the webauthn.User interface.
+type WebAuthnUser struct {
+	User        *models.User
+	Credentials []models.WebAuthnCredential
+}
+
+// WebAuthnID returns the user handle stored on the authenticator.
+func (u *WebAuthnUser) WebAuthnID() []byte {
+	return []byte(u.User.ID)
+}
+
+// WebAuthnName returns the name shown to identify the account on the authenticator.
+func (u *WebAuthnUser) WebAuthnName() string {
+	return u.User.Username
+}
+
+// WebAuthnDisplayName returns the display name of the account.
+func (u *WebAuthnUser) WebAuthnDisplayName() string {
+	return u.User.DisplayName
+}
+
+// WebAuthnIcon is deprecated in the specification and left empty.
+func (u *WebAuthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+// WebAuthnCredentials returns the passkeys registered by the user.
+func (u *WebAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, len(u.Credentials))
+	for i, credential := range u.Credentials {
+		transports := make([]protocol.AuthenticatorTransport, len(credential.Transports))
+		for j, transport := range credential.Transports {
+			transports[j] = protocol.AuthenticatorTransport(transport)
+		}
+
+		credentials[i] = webauthn.Credential{
+			ID:              credential.CredentialID,
+			PublicKey:       credential.PublicKey,
+			AttestationType: credential.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: credential.BackupEligible,
+				BackupState:    credential.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    credential.AAGUID,
+				SignCount: credential.SignCount,
+			},
+		}
+	}
+
+	return credentials
+}
+
+// NewWebAuthn creates the relying party from the current configuration.
+func NewWebAuthn() (*webauthn.WebAuthn, error) {
+	return webauthn.New(&webauthn.Config{
+		RPID:          cfg.Config.Domain,
+		RPDisplayName: "Twibber",
+		RPOrigins:     []string{cfg.Config.PublicURL},
+	})
+}
+
+// LoadWebAuthnUser loads the user along with every passkey they have registered.
+func LoadWebAuthnUser(tx *gorm.DB, userID string) (*WebAuthnUser, error) {
+	var user models.User
+	if err := tx.Where("id = ?", userID).First(&user).Error; err != nil {
+		return nil, err
+	}
+
+	var credentials []models.WebAuthnCredential
+	if err := tx.Joins("Connection").
+		Where(`"Connection"."user_id" = ?`, userID).
+		Find(&credentials).Error; err != nil {
+		return nil, err
+	}
+
+	return &WebAuthnUser{User: &user, Credentials: credentials}, nil
+}
+
+// BeginWebAuthnRegistration starts registering a new passkey for the user.
+func BeginWebAuthnRegistration(wa *webauthn.WebAuthn, user *WebAuthnUser) (*protocol.CredentialCreation, *webauthn.SessionData, error) {
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(user.Credentials))
+	for _, credential := range user.WebAuthnCredentials() {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+
+	return wa.BeginRegistration(user,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+}
+
+// FinishWebAuthnRegistration verifies the authenticator's attestation response and returns the new credential.
+func FinishWebAuthnRegistration(wa *webauthn.WebAuthn, user *WebAuthnUser, session webauthn.SessionData, response []byte) (*webauthn.Credential, error) {
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(response))
+	if err != nil {
+		return nil, ErrInvalidPasskey
+	}
+
+	credential, err := wa.CreateCredential(user, session, parsed)
+	if err != nil {
+		return nil, ErrInvalidPasskey
+	}
+
+	return credential, nil
+}
+
+// BeginWebAuthnLogin starts a usernameless passkey login.
+func BeginWebAuthnLogin(wa *webauthn.WebAuthn) (*protocol.CredentialAssertion, *webauthn.SessionData, error) {
+	return wa.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+}
+
+// FinishWebAuthnLogin verifies the authenticator's assertion and returns the user and the credential used.
+// The returned credential carries the new signature counter, which must be stored by the caller.
+func FinishWebAuthnLogin(wa *webauthn.WebAuthn, session webauthn.SessionData, response []byte, loadUser func(userID string) (*WebAuthnUser, error)) (*WebAuthnUser, *webauthn.Credential, error) {
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(response))
+	if err != nil {
+		return nil, nil, ErrInvalidPasskey
+	}
+
+	var user *WebAuthnUser
+	credential, err := wa.ValidateDiscoverableLogin(func(_, userHandle []byte) (webauthn.User, error) {
+		user, err = loadUser(string(userHandle))
+		return user, err
+	}, session, parsed)
+	if err != nil {
+		return nil, nil, ErrInvalidPasskey
+	}
+
+	// A counter which did not increase suggests the authenticator has been cloned.
+	if credential.Authenticator.CloneWarning {
+		return nil, nil, ErrInvalidPasskey
+	}
+
+	return user, credential, nil
+}
+
+// WebAuthnConnectionID returns the connection ID used for a passkey credential.
+func WebAuthnConnectionID(credentialID []byte) string {
+	return models.ProviderWebAuthnType.WithID(base64.RawURLEncoding.EncodeToString(credentialID))
+}
+
+// SaveWebAuthnCeremony stores the challenge data of a ceremony and returns the token identifying it.
+func SaveWebAuthnCeremony(tx *gorm.DB, userID *string, session *webauthn.SessionData) (string, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	token := GenerateString(64)
+
+	if err := tx.Create(&models.WebAuthnCeremony{
+		BaseModel: models.BaseModel{
+			ID: HashToken(token),
+		},
+		UserID:    userID,
+		Data:      string(data),
+		ExpiresAt: time.Now().Add(WebAuthnCeremonyDuration),
+	}).Error; err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// TakeWebAuthnCeremony loads and deletes a ceremony so its challenge can only be answered once.
+func TakeWebAuthnCeremony(tx *gorm.DB, token string) (*models.WebAuthnCeremony, *webauthn.SessionData, error) {
+	var ceremony models.WebAuthnCeremony
+	if err := tx.Where(models.WebAuthnCeremony{
+		BaseModel: models.BaseModel{
+			ID: HashToken(token),
+		},
+	}).First(&ceremony).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrInvalidPasskey
+		}
+		return nil, nil, err
+	}
+
+	result := tx.Delete(&ceremony)
+	if result.Error != nil {
+		return nil, nil, result.Error
+	}
+
+	// Another request completed the ceremony in the meantime.
+	if result.RowsAffected == 0 || ceremony.ExpiresAt.Before(time.Now()) {
+		return nil, nil, ErrInvalidPasskey
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(ceremony.Data), &session); err != nil {
+		return nil, nil, err
+	}
+
+	return &ceremony, &session, nil
+}
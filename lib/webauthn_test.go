@@ -0,0 +1,334 @@
+package lib
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+	cfg "github.com/twibber/api/config"
+	"github.com/twibber/api/models"
+	"testing"
+)
+
+const (
+	testRPID   = "twibber.test"
+	testOrigin = "https://twibber.test"
+)
+
+// softAuthenticator is an authenticator implemented in software, holding a single P-256 credential.
+type softAuthenticator struct {
+	key       *ecdsa.PrivateKey
+	id        []byte
+	signCount uint32
+	origin    string // Origin the browser reports the ceremony as coming from
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		t.Fatal(err)
+	}
+
+	return &softAuthenticator{key: key, id: id, origin: testOrigin}
+}
+
+// publicKey returns the COSE encoding of the credential's public key.
+func (a *softAuthenticator) publicKey(t *testing.T) []byte {
+	t.Helper()
+
+	key, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1, // P-256
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+// authData builds the authenticator data, including the attested credential when registering.
+func (a *softAuthenticator) authData(t *testing.T, attest bool) []byte {
+	t.Helper()
+
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	flags := protocol.FlagUserPresent | protocol.FlagUserVerified
+	if attest {
+		flags |= protocol.FlagAttestedCredentialData
+	}
+
+	data := append(rpIDHash[:], byte(flags))
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+
+	if attest {
+		data = append(data, make([]byte, 16)...) // AAGUID
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.id)))
+		data = append(data, a.id...)
+		data = append(data, a.publicKey(t)...)
+	}
+
+	return data
+}
+
+// clientData builds the client data the browser would produce for the ceremony.
+func (a *softAuthenticator) clientData(t *testing.T, ceremony string, challenge []byte) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    a.origin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// create answers a registration challenge with a "none" attestation.
+func (a *softAuthenticator) create(t *testing.T, challenge []byte) []byte {
+	t.Helper()
+
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(t, true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.response(t, map[string]string{
+		"clientDataJSON":    b64(a.clientData(t, "webauthn.create", challenge)),
+		"attestationObject": b64(attestation),
+	})
+}
+
+// get answers a login challenge, signing it as the user's discoverable credential.
+func (a *softAuthenticator) get(t *testing.T, challenge, userHandle []byte) []byte {
+	t.Helper()
+
+	authData := a.authData(t, false)
+	clientData := a.clientData(t, "webauthn.get", challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.response(t, map[string]string{
+		"clientDataJSON":    b64(clientData),
+		"authenticatorData": b64(authData),
+		"signature":         b64(signature),
+		"userHandle":        b64(userHandle),
+	})
+}
+
+// response wraps the authenticator response in the credential the browser sends to the server.
+func (a *softAuthenticator) response(t *testing.T, response map[string]string) []byte {
+	t.Helper()
+
+	body, err := json.Marshal(map[string]any{
+		"id":       b64(a.id),
+		"rawId":    b64(a.id),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return body
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// testWebAuthn returns the relying party for the test domain, along with a user without passkeys.
+func testWebAuthn(t *testing.T) (*webauthn.WebAuthn, *WebAuthnUser) {
+	t.Helper()
+
+	domain, publicURL := cfg.Config.Domain, cfg.Config.PublicURL
+	cfg.Config.Domain, cfg.Config.PublicURL = testRPID, testOrigin
+	t.Cleanup(func() {
+		cfg.Config.Domain, cfg.Config.PublicURL = domain, publicURL
+	})
+
+	wa, err := NewWebAuthn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return wa, &WebAuthnUser{User: &models.User{
+		BaseModel:   models.BaseModel{ID: "0b0e5b3c-4d3a-4f0e-9d51-3f1c2a7d8e90"},
+		Username:    "passkey",
+		DisplayName: "Passkey",
+	}}
+}
+
+// register runs a registration ceremony and stores the credential on the user as a handler would.
+func register(t *testing.T, wa *webauthn.WebAuthn, user *WebAuthnUser, authenticator *softAuthenticator) {
+	t.Helper()
+
+	options, session, err := BeginWebAuthnRegistration(wa, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credential, err := FinishWebAuthnRegistration(wa, user, *session, authenticator.create(t, options.Response.Challenge))
+	if err != nil {
+		t.Fatalf("registration failed: %v", err)
+	}
+
+	user.Credentials = append(user.Credentials, models.WebAuthnCredential{
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+	})
+}
+
+// login runs a login ceremony and returns the credential used, storing its new counter on the user.
+func login(t *testing.T, wa *webauthn.WebAuthn, user *WebAuthnUser, authenticator *softAuthenticator) (*webauthn.Credential, error) {
+	t.Helper()
+
+	options, session, err := BeginWebAuthnLogin(wa)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := authenticator.get(t, options.Response.Challenge, user.WebAuthnID())
+	found, credential, err := FinishWebAuthnLogin(wa, *session, response, func(userID string) (*WebAuthnUser, error) {
+		if userID != user.User.ID {
+			t.Fatalf("user handle %q was not the user's ID", userID)
+		}
+		return user, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if found != user {
+		t.Fatal("login returned a different user")
+	}
+	user.Credentials[0].SignCount = credential.Authenticator.SignCount
+
+	return credential, nil
+}
+
+func TestWebAuthnRegistrationAndLogin(t *testing.T) {
+	wa, user := testWebAuthn(t)
+	authenticator := newSoftAuthenticator(t)
+
+	register(t, wa, user, authenticator)
+
+	if string(user.Credentials[0].CredentialID) != string(authenticator.id) {
+		t.Fatal("registered credential has the wrong ID")
+	}
+
+	for count := uint32(1); count <= 3; count++ {
+		authenticator.signCount = count
+
+		credential, err := login(t, wa, user, authenticator)
+		if err != nil {
+			t.Fatalf("login %d failed: %v", count, err)
+		}
+
+		if credential.Authenticator.SignCount != count {
+			t.Fatalf("sign count = %d, want %d", credential.Authenticator.SignCount, count)
+		}
+	}
+}
+
+func TestWebAuthnLoginRejectsCounterRegression(t *testing.T) {
+	wa, user := testWebAuthn(t)
+	authenticator := newSoftAuthenticator(t)
+
+	register(t, wa, user, authenticator)
+
+	authenticator.signCount = 5
+	if _, err := login(t, wa, user, authenticator); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+
+	// A clone of the authenticator would still be on an older count.
+	for _, count := range []uint32{5, 4} {
+		authenticator.signCount = count
+		if _, err := login(t, wa, user, authenticator); err != ErrInvalidPasskey {
+			t.Fatalf("login with count %d: got %v, want ErrInvalidPasskey", count, err)
+		}
+	}
+}
+
+func TestWebAuthnRejectsWrongOrigin(t *testing.T) {
+	wa, user := testWebAuthn(t)
+	authenticator := newSoftAuthenticator(t)
+	authenticator.origin = "https://twibber.example"
+
+	options, session, err := BeginWebAuthnRegistration(wa, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := FinishWebAuthnRegistration(wa, user, *session, authenticator.create(t, options.Response.Challenge)); err != ErrInvalidPasskey {
+		t.Fatalf("registration from the wrong origin: got %v, want ErrInvalidPasskey", err)
+	}
+
+	authenticator.origin = testOrigin
+	register(t, wa, user, authenticator)
+
+	authenticator.origin = "https://twibber.example"
+	authenticator.signCount = 1
+	if _, err := login(t, wa, user, authenticator); err != ErrInvalidPasskey {
+		t.Fatalf("login from the wrong origin: got %v, want ErrInvalidPasskey", err)
+	}
+}
+
+func TestWebAuthnRejectsReplayedChallenge(t *testing.T) {
+	wa, user := testWebAuthn(t)
+	authenticator := newSoftAuthenticator(t)
+
+	register(t, wa, user, authenticator)
+
+	// An answer to one challenge cannot complete another ceremony.
+	stale, _, err := BeginWebAuthnLogin(wa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, session, err := BeginWebAuthnLogin(wa)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator.signCount = 1
+	response := authenticator.get(t, stale.Response.Challenge, user.WebAuthnID())
+	if _, _, err := FinishWebAuthnLogin(wa, *session, response, func(string) (*WebAuthnUser, error) {
+		return user, nil
+	}); err != ErrInvalidPasskey {
+		t.Fatalf("login with another ceremony's challenge: got %v, want ErrInvalidPasskey", err)
+	}
+}
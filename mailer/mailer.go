@@ -22,8 +22,9 @@ var (
 	htmlTmpl *htmlTemplate.Template
 )
 
-// init sets up the mailer with the appropriate configuration and parses the templates.
-func init() {
+// Configure sets up the mailer with the appropriate configuration and parses the templates.
+// It is called on startup rather than on import, so packages can be tested without a mail server or the templates.
+func Configure() {
 	// Convert the configured port to an integer.
 	port, err := strconv.Atoi(cfg.Config.MailPort)
 	if err != nil {
@@ -16,3 +16,75 @@ type VerifyDTO struct {
 func (data VerifyDTO) Send() error {
 	return Send("Verify your Twibber Account", "user_verify", data)
 }
+
+// PasswordResetDTO struct holds the data required for sending a password reset email.
+type PasswordResetDTO struct {
+	Defaults
+	Link string
+}
+
+// Send sends the password reset email.
+func (data PasswordResetDTO) Send() error {
+	return Send("Reset your Twibber password", "user_password_reset", data)
+}
+
+// MagicLinkDTO struct holds the data required for sending a login link email.
+type MagicLinkDTO struct {
+	Defaults
+	Link string
+}
+
+// Send sends the login link email.
+func (data MagicLinkDTO) Send() error {
+	return Send("Your Twibber login link", "user_magic_link", data)
+}
+
+// EmailChangeDTO struct holds the data required for sending a confirmation email to a new address.
+type EmailChangeDTO struct {
+	Defaults
+	Link string
+}
+
+// Send sends the new address confirmation email.
+func (data EmailChangeDTO) Send() error {
+	return Send("Confirm your new Twibber email address", "user_email_change", data)
+}
+
+// EmailChangedDTO struct holds the data required for notifying the old address of an email change.
+type EmailChangedDTO struct {
+	Defaults
+	NewEmail string
+	Link     string
+}
+
+// Send sends the email change notification.
+func (data EmailChangedDTO) Send() error {
+	return Send("Your Twibber email address was changed", "user_email_changed", data)
+}
+
+// AccountLockedDTO struct holds the data required for notifying a user that their account was locked.
+type AccountLockedDTO struct {
+	Defaults
+	IP      string // IP address the last failed attempt came from
+	Minutes int    // How long the account is locked for
+}
+
+// Send sends the account locked notification.
+func (data AccountLockedDTO) Send() error {
+	return Send("Your Twibber account has been locked", "user_account_locked", data)
+}
+
+// NewSignInDTO struct holds the data required for notifying a user of a login from a new device.
+type NewSignInDTO struct {
+	Defaults
+	Browser string
+	OS      string
+	IP      string
+	Time    string
+	Link    string // Link to revoke the session if the login was not made by the user
+}
+
+// Send sends the new sign-in notification.
+func (data NewSignInDTO) Send() error {
+	return Send("New sign-in to your Twibber account", "user_new_sign_in", data)
+}
@@ -7,6 +7,8 @@ import (
 
 	log "github.com/sirupsen/logrus" // Logrus - Structured logger for Go
 
+	"github.com/twibber/api/lib"    // Library containing the database connection
+	"github.com/twibber/api/mailer" // Mailer used to send emails
 	"github.com/twibber/api/router" // Router package for handling HTTP routes
 )
 
@@ -30,6 +32,14 @@ func init() {
 
 // The main function starts the HTTP listener and logs fatal errors if the server fails to start.
 func main() {
+	// Refusing to start with a secret key which would let signed values be forged.
+	lib.CheckSecretKey()
+
+	// Connecting to the services the API depends on.
+	lib.ConnectDB()
+	mailer.Configure()
+	lib.ConfigureCaptcha()
+
 	// Starting the HTTP server and listening on the configured port.
 	if err := router.Configure().Listen(fmt.Sprintf("%s:%s", "0.0.0.0", cfg.Config.Port)); err != nil {
 		log.WithError(err).WithField("port", cfg.Config.Port).Fatal("failed to start listener")
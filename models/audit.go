@@ -0,0 +1,29 @@
+package models
+
+// AuditAction represents what a staff member did.
+type AuditAction string
+
+// Predefined constants for AuditAction.
+const (
+	AuditImpersonateStart   AuditAction = "impersonate.start"
+	AuditImpersonateRequest AuditAction = "impersonate.request"
+	AuditSuspend            AuditAction = "user.suspend"
+	AuditUnsuspend          AuditAction = "user.unsuspend"
+)
+
+// AuditLog records an action taken by a staff member, such as a request made while impersonating a user.
+type AuditLog struct {
+	BaseModel
+
+	ActorID   string      `gorm:"not null;index" json:"actor_id"` // Staff member who took the action
+	Actor     *User       `gorm:"foreignKey:ActorID;references:ID;constraint:OnDelete:CASCADE" json:"actor,omitempty"`
+	SubjectID *string     `gorm:"index" json:"subject_id,omitempty"` // User the action was taken on or as
+	Subject   *User       `gorm:"foreignKey:SubjectID;references:ID;constraint:OnDelete:SET NULL" json:"subject,omitempty"`
+	SessionID *string     `json:"session_id,omitempty"` // Impersonation session the action was taken with
+	Action    AuditAction `gorm:"size:64;not null;index" json:"action"`
+	Method    string      `gorm:"size:16" json:"method,omitempty"`
+	Path      string      `gorm:"size:255" json:"path,omitempty"`
+	Status    int         `json:"status,omitempty"` // HTTP status the request was answered with
+	IP        string      `gorm:"size:64" json:"ip"`
+	Details   string      `gorm:"type:text" json:"details,omitempty"` // Action specific details, such as the reason for a suspension
+}
@@ -10,9 +10,10 @@ type ConnectionType string
 
 // Predefined constants for ConnectionType.
 const (
-	ProviderEmailType  ConnectionType = "email"
-	ProviderGoogleType ConnectionType = "google"
-	ProviderGitHubType ConnectionType = "github"
+	ProviderEmailType    ConnectionType = "email"
+	ProviderGoogleType   ConnectionType = "google"
+	ProviderGitHubType   ConnectionType = "github"
+	ProviderWebAuthnType ConnectionType = "webauthn"
 )
 
 func (c ConnectionType) WithID(id string) string {
@@ -35,14 +36,149 @@ type Connection struct {
 type Session struct {
 	BaseModel
 
+	TokenHash    string      `gorm:"uniqueIndex" json:"-"` // SHA-256 hash of the auth token, the token itself is never stored
 	ConnectionID string      `gorm:"not null" json:"-"`
 	Connection   *Connection `gorm:"foreignKey:ConnectionID;references:ID;constraint:OnDelete:CASCADE" json:"connection,omitempty"`
 	Info         SessionInfo `gorm:"embedded;embeddedPrefix:info_" json:"info,omitempty"`
-	ExpiresAt    time.Time   `gorm:"not null" json:"expires_at"`
+	RememberMe   bool        `gorm:"not null;default:false" json:"remember_me"` // Whether the session uses the longer "remember me" lifetimes
+	ExpiresAt    time.Time   `gorm:"not null" json:"expires_at"`                // Extended on activity, up to MaxExpiresAt
+	MaxExpiresAt *time.Time  `json:"max_expires_at,omitempty"`                  // Time the session expires regardless of activity
+
+	ImpersonatorID *string `gorm:"index" json:"-"` // Staff member acting as the user, empty for sessions the user logged in to
+	Impersonator   *User   `gorm:"foreignKey:ImpersonatorID;references:ID;constraint:OnDelete:CASCADE" json:"-"`
+	Impersonated   bool    `gorm:"not null;default:false" json:"impersonated"` // Whether the session was created by a staff member impersonating the user
 }
 
 // SessionInfo holds information about the session such as IP address and user agent.
 type SessionInfo struct {
 	IPAddresses pq.StringArray `gorm:"type:text[]" json:"ip_addresses,omitempty"`
 	UserAgent   string         `gorm:"size:255" json:"user_agent,omitempty"`
+	Browser     string         `gorm:"size:64" json:"browser,omitempty"` // Browser name and version parsed from the user agent
+	OS          string         `gorm:"size:64" json:"os,omitempty"`      // Operating system parsed from the user agent
+	Device      string         `gorm:"size:16" json:"device,omitempty"`  // "desktop", "mobile" or "bot"
+	LastSeenAt  *time.Time     `json:"last_seen_at,omitempty"`           // Time the session was last used
+	LastIP      string         `gorm:"size:64" json:"last_ip,omitempty"` // IP address the session was last used from
+}
+
+// APIToken is a personal access token a user has created for bots and integrations,
+// or an access token issued to an OAuth client.
+// Only the hash of the token is stored, it is shown to the user once when created.
+type APIToken struct {
+	BaseModel
+
+	UserID     string         `gorm:"not null;index" json:"-"`
+	User       *User          `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE" json:"user,omitempty"`
+	ClientID   *string        `gorm:"index" json:"-"` // OAuth client the token was issued to, empty for personal tokens
+	Client     *OAuthClient   `gorm:"foreignKey:ClientID;references:ID;constraint:OnDelete:CASCADE" json:"client,omitempty"`
+	Name       string         `gorm:"size:64;not null" json:"name"`          // Name given to the token by the user
+	TokenHash  string         `gorm:"not null;uniqueIndex" json:"-"`         // SHA-256 hash of the token
+	Hint       string         `gorm:"size:16" json:"hint"`                   // Start of the token, to help users tell tokens apart
+	Scopes     pq.StringArray `gorm:"type:text[]" json:"scopes"`             // Scopes the token has been granted
+	ExpiresAt  *time.Time     `json:"expires_at,omitempty"`                  // Time the token stops working, never if empty
+	LastUsedAt *time.Time     `json:"last_used_at,omitempty"`                // Time the token was last used
+	LastUsedIP string         `gorm:"size:64" json:"last_used_ip,omitempty"` // IP address the token was last used from
+}
+
+// KnownDevice records a browser, OS and network combination a connection has logged in from,
+// so logins from anywhere else can be reported to the user.
+type KnownDevice struct {
+	BaseModel
+
+	ConnectionID string      `gorm:"not null;uniqueIndex:idx_known_device" json:"-"`
+	Connection   *Connection `gorm:"foreignKey:ConnectionID;references:ID;constraint:OnDelete:CASCADE" json:"connection,omitempty"`
+	Fingerprint  string      `gorm:"not null;uniqueIndex:idx_known_device" json:"-"` // Hash of the browser, OS and IP prefix
+	LastSeenAt   time.Time   `gorm:"not null" json:"last_seen_at"`
+}
+
+// MFAChallenge represents a login which has passed the first factor and is awaiting an MFA code.
+type MFAChallenge struct {
+	BaseModel
+
+	ConnectionID string      `gorm:"not null" json:"-"`
+	Connection   *Connection `gorm:"foreignKey:ConnectionID;references:ID;constraint:OnDelete:CASCADE" json:"connection,omitempty"`
+	Attempts     int         `gorm:"not null;default:0" json:"-"`     // Number of failed attempts at completing the challenge
+	RememberMe   bool        `gorm:"not null;default:false" json:"-"` // Whether the session issued on completion should be remembered
+	ExpiresAt    time.Time   `gorm:"not null" json:"expires_at"`
+}
+
+// RecoveryCode represents a one-time code which can be used in place of an MFA code.
+type RecoveryCode struct {
+	BaseModel
+
+	UserID string     `gorm:"not null;index" json:"-"`
+	User   *User      `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE" json:"user,omitempty"`
+	Hash   string     `gorm:"not null" json:"-"`                // Argon2id hash of the code, not exposed through API
+	UsedAt *time.Time `json:"used_at,omitempty"`                // Time the code was used, if it has been
+	UsedIP string     `gorm:"size:64" json:"used_ip,omitempty"` // IP address the code was used from
+}
+
+// AttemptCounter tracks failed attempts against a key, such as an account or IP address, when counters are kept in the database.
+// The ID is the key being throttled.
+type AttemptCounter struct {
+	BaseModel
+
+	Failures      int        `gorm:"not null;default:0" json:"failures"` // Consecutive failures within the policy's window
+	LastFailureAt time.Time  `gorm:"not null" json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"` // Time until which attempts are refused
+}
+
+// RateLimitBucket is a token bucket used for rate limiting when buckets are kept in the database.
+// The ID is the policy name followed by the user ID or IP address being limited.
+type RateLimitBucket struct {
+	ID         string    `gorm:"primaryKey" json:"id"`
+	Tokens     float64   `gorm:"type:double precision;not null" json:"tokens"` // Tokens left as of RefilledAt
+	Allowed    bool      `gorm:"not null" json:"allowed"`                      // Whether the last request took a token
+	RefilledAt time.Time `gorm:"not null;index" json:"refilled_at"`            // Time the tokens were last topped up
+}
+
+// TokenPurpose represents what a one-time token may be used for.
+type TokenPurpose string
+
+// Predefined constants for TokenPurpose.
+const (
+	TokenPasswordReset TokenPurpose = "password_reset"
+	TokenMagicLink     TokenPurpose = "magic_link"
+	TokenEmailChange   TokenPurpose = "email_change"
+	TokenEmailRevert   TokenPurpose = "email_revert"
+	TokenSessionRevoke TokenPurpose = "session_revoke"
+)
+
+// OneTimeToken represents a single-use token sent to a user, such as a password reset link.
+// The ID is the hash of the token, the token itself is never stored.
+type OneTimeToken struct {
+	BaseModel
+
+	Purpose      TokenPurpose `gorm:"not null;index" json:"purpose"`
+	ConnectionID string       `gorm:"not null;index" json:"-"`
+	Connection   *Connection  `gorm:"foreignKey:ConnectionID;references:ID;constraint:OnDelete:CASCADE" json:"connection,omitempty"`
+	Data         string       `json:"-"` // Purpose specific data, not exposed through API
+	ExpiresAt    time.Time    `gorm:"not null" json:"expires_at"`
+	UsedAt       *time.Time   `json:"used_at,omitempty"`
+}
+
+// WebAuthnCredential stores a passkey registered against a webauthn connection.
+type WebAuthnCredential struct {
+	BaseModel
+
+	ConnectionID    string         `gorm:"not null;uniqueIndex" json:"-"`
+	Connection      *Connection    `gorm:"foreignKey:ConnectionID;references:ID;constraint:OnDelete:CASCADE" json:"connection,omitempty"`
+	Name            string         `gorm:"size:64" json:"name"`                     // Name given to the passkey by the user
+	CredentialID    []byte         `gorm:"not null;uniqueIndex" json:"-"`           // ID of the credential on the authenticator
+	PublicKey       []byte         `gorm:"not null" json:"-"`                       // COSE encoded public key of the credential
+	AttestationType string         `json:"attestation_type"`                        // Attestation format provided at registration
+	AAGUID          []byte         `json:"aaguid"`                                  // Model identifier of the authenticator
+	SignCount       uint32         `gorm:"not null;default:0" json:"sign_count"`    // Last signature counter seen, used to detect cloned authenticators
+	Transports      pq.StringArray `gorm:"type:text[]" json:"transports,omitempty"` // Transports the authenticator supports
+	BackupEligible  bool           `gorm:"not null;default:false" json:"backup_eligible"`
+	BackupState     bool           `gorm:"not null;default:false" json:"backup_state"`
+}
+
+// WebAuthnCeremony holds the challenge of a passkey registration or login which is in progress.
+// The ID is the hash of the ceremony token given to the client.
+type WebAuthnCeremony struct {
+	BaseModel
+
+	UserID    *string   `json:"-"`                           // User registering a passkey, empty for logins
+	Data      string    `gorm:"type:text;not null" json:"-"` // JSON encoded challenge data
+	ExpiresAt time.Time `gorm:"not null" json:"expires_at"`
 }
@@ -8,11 +8,32 @@ import (
 
 var Models = []any{
 	&User{},
+	&Role{},
+	&UserRole{},
+	&AuditLog{},
 	&Connection{},
 	&Session{},
+	&KnownDevice{},
+	&OAuthClient{},
+	&OAuthAuthorization{},
+	&OAuthCode{},
+	&OAuthRefreshToken{},
+	&APIToken{},
+	&MFAChallenge{},
+	&RecoveryCode{},
+	&AttemptCounter{},
+	&RateLimitBucket{},
+	&OneTimeToken{},
+	&WebAuthnCredential{},
+	&WebAuthnCeremony{},
 	&Post{},
 	&Like{},
+	&ConversationMute{},
 	&Follow{},
+	&TimelineEntry{},
+	&TimelineBuild{},
+	&Mute{},
+	&Block{},
 }
 
 // BaseModel defines the basic structure for database models.
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"github.com/lib/pq"
+	"time"
+)
+
+// OAuthClient is a third-party application registered to act on behalf of users through OAuth2.
+// The ID is the client ID given to the application.
+type OAuthClient struct {
+	BaseModel
+
+	OwnerID      string         `gorm:"not null;index" json:"-"`
+	Owner        *User          `gorm:"foreignKey:OwnerID;references:ID;constraint:OnDelete:CASCADE" json:"owner,omitempty"`
+	Name         string         `gorm:"size:64;not null" json:"name"`              // Name shown to users on the consent screen
+	Website      string         `gorm:"size:255" json:"website,omitempty"`         // Homepage of the application
+	RedirectURIs pq.StringArray `gorm:"type:text[];not null" json:"redirect_uris"` // Exact URIs users may be sent back to
+	SecretHash   string         `json:"-"`                                         // SHA-256 hash of the client secret, empty for public clients
+	Public       bool           `gorm:"not null;default:false" json:"public"`      // Public clients, such as mobile apps, cannot keep a secret
+}
+
+// OAuthAuthorization records a user's consent for a client to act on their behalf with the given scopes.
+type OAuthAuthorization struct {
+	BaseModel
+
+	UserID   string         `gorm:"not null;uniqueIndex:idx_oauth_authorization" json:"-"`
+	User     *User          `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE" json:"user,omitempty"`
+	ClientID string         `gorm:"not null;uniqueIndex:idx_oauth_authorization" json:"-"`
+	Client   *OAuthClient   `gorm:"foreignKey:ClientID;references:ID;constraint:OnDelete:CASCADE" json:"client,omitempty"`
+	Scopes   pq.StringArray `gorm:"type:text[]" json:"scopes"` // Scopes the user has granted the client
+}
+
+// OAuthCode is an authorization code waiting to be exchanged for tokens by the client.
+// The ID is the hash of the code.
+type OAuthCode struct {
+	BaseModel
+
+	ClientID      string         `gorm:"not null;index" json:"-"`
+	Client        *OAuthClient   `gorm:"foreignKey:ClientID;references:ID;constraint:OnDelete:CASCADE" json:"client,omitempty"`
+	UserID        string         `gorm:"not null" json:"-"`
+	User          *User          `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE" json:"user,omitempty"`
+	RedirectURI   string         `gorm:"not null" json:"-"`         // Must match the URI given when exchanging the code
+	Scopes        pq.StringArray `gorm:"type:text[]" json:"scopes"` // Scopes granted by the user
+	CodeChallenge string         `gorm:"not null" json:"-"`         // S256 PKCE challenge the verifier must match
+	ExpiresAt     time.Time      `gorm:"not null" json:"expires_at"`
+}
+
+// OAuthRefreshToken allows a client to obtain new access tokens without the user being present.
+// The ID is the hash of the token, tokens are replaced each time they are used.
+type OAuthRefreshToken struct {
+	BaseModel
+
+	ClientID  string         `gorm:"not null;index" json:"-"`
+	Client    *OAuthClient   `gorm:"foreignKey:ClientID;references:ID;constraint:OnDelete:CASCADE" json:"client,omitempty"`
+	UserID    string         `gorm:"not null;index" json:"-"`
+	User      *User          `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE" json:"user,omitempty"`
+	Scopes    pq.StringArray `gorm:"type:text[]" json:"scopes"`
+	ExpiresAt time.Time      `gorm:"not null" json:"expires_at"`
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 // PostType represents the type of the post.
 type PostType string
 
@@ -20,6 +22,9 @@ type Post struct {
 	ParentID *string `gorm:"index" json:"parent_id,omitempty"`                                                                         // ID of the parent post, if this is a reply or repost
 	Parent   *Post   `gorm:"foreignKey:ParentID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;" json:"parent,omitempty"` // The parent post
 
+	// Replies share the conversation of the post they reply to, every other post starts its own.
+	ConversationID string `gorm:"index" json:"conversation_id"` // ID of the post at the root of the conversation
+
 	Type    PostType `json:"type"` // The type of the post (post, reply, repost)
 	Content *string  `gorm:"type:text" json:"content,omitempty"`
 
@@ -29,6 +34,9 @@ type Post struct {
 	// Ignored by GORM and populated by the handler.
 	Liked bool `gorm:"-" json:"liked,omitempty"` // Flag indicating whether the post was liked by the current user
 
+	// Ignored by GORM and populated by the thread handler.
+	MoreReplies string `gorm:"-" json:"more_replies,omitempty"` // Cursor to pass as ?after= to the post's thread for the replies left out of a thread
+
 	// Counts are ignored by GORM and are populated by the handler.
 	Counts struct {
 		Likes   int `gorm:"-" json:"likes"`   // Number of likes on the post
@@ -44,6 +52,38 @@ type Like struct {
 	UserID string `gorm:"not null" json:"user_id"`                                                                             // ID of the user who liked the post
 	User   *User  `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"user,omitempty"` // The user who liked the post
 
-	PostID string `gorm:"not null" json:"post_id"`                                                                             // ID of the post that was liked
+	PostID string `gorm:"not null;index" json:"post_id"`                                                                       // ID of the post that was liked
 	Post   *Post  `gorm:"foreignKey:PostID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"post,omitempty"` // The post that was liked
 }
+
+// ConversationMute represents a User hiding a whole conversation from their timeline.
+// The conversation is not a foreign key, as its replies remain when the post at its root is deleted.
+type ConversationMute struct {
+	BaseModel
+
+	UserID string `gorm:"not null;uniqueIndex:idx_conversation_mute" json:"user_id"`                                           // ID of the user who muted the conversation
+	User   User   `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"user,omitempty"` // The user who muted the conversation
+
+	ConversationID string `gorm:"not null;uniqueIndex:idx_conversation_mute" json:"conversation_id"` // ID of the post at the root of the conversation, which may have been deleted
+}
+
+// TimelineEntry is a post delivered to a user's home timeline, when timelines are materialized in the database.
+// Each post or repost is its own entry, so removing one leaves other appearances of the same original in place,
+// and only the most recent appearance of each original is shown when the timeline is read.
+type TimelineEntry struct {
+	UserID     string    `gorm:"primaryKey;index:idx_timeline_page,priority:1;index:idx_timeline_original,priority:1" json:"user_id"` // ID of the user whose timeline the entry is on
+	User       *User     `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`              // The user whose timeline the entry is on
+	PostID     string    `gorm:"primaryKey;index;index:idx_timeline_page,priority:3" json:"post_id"`                                  // ID of the post or repost
+	Post       *Post     `gorm:"foreignKey:PostID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`              // The post or repost
+	OriginalID string    `gorm:"not null;index;index:idx_timeline_original,priority:2" json:"original_id"`                            // ID of the post being shown, the parent of a repost
+	AuthorID   string    `gorm:"not null" json:"author_id"`                                                                           // ID of the user who made the post or repost
+	PostedAt   time.Time `gorm:"not null;index:idx_timeline_page,priority:2" json:"posted_at"`                                        // Time the post or repost was made
+}
+
+// TimelineBuild marks a user's materialized home timeline as built from the follow graph,
+// after which it is only kept up to date by delivering new posts to it.
+type TimelineBuild struct {
+	UserID  string    `gorm:"primaryKey" json:"user_id"`                                                              // ID of the user whose timeline was built
+	User    *User     `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"` // The user whose timeline was built
+	BuiltAt time.Time `gorm:"not null" json:"built_at"`                                                               // Time the timeline was built
+}
@@ -0,0 +1,24 @@
+package models
+
+import "github.com/lib/pq"
+
+// Role is a named set of permissions which can be granted to users, such as "moderator".
+// The ID is the name of the role.
+type Role struct {
+	BaseModel
+
+	Description string         `gorm:"size:255" json:"description"`
+	Permissions pq.StringArray `gorm:"type:text[];not null" json:"permissions"` // Permissions held by every user with the role
+}
+
+// UserRole grants a role to a user.
+type UserRole struct {
+	BaseModel
+
+	UserID      string  `gorm:"not null;uniqueIndex:idx_user_role" json:"-"`
+	User        *User   `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE" json:"user,omitempty"`
+	RoleID      string  `gorm:"not null;uniqueIndex:idx_user_role" json:"role_id"`
+	Role        *Role   `gorm:"foreignKey:RoleID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"role,omitempty"`
+	GrantedByID *string `json:"granted_by_id,omitempty"` // User who granted the role, empty if granted by a migration
+	GrantedBy   *User   `gorm:"foreignKey:GrantedByID;references:ID;constraint:OnDelete:SET NULL" json:"granted_by,omitempty"`
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"github.com/twibber/api/img"
 	"gorm.io/gorm"
+	"time"
 )
 
 var (
@@ -25,13 +26,17 @@ type User struct {
 	Avatar string `json:"avatar"` // URL to the user's avatar image
 	Banner string `json:"banner"` // URL to the user's banner image
 
-	Admin          bool `gorm:"not null;default:false" json:"admin"`           // Flag indicating whether the user has administrative privileges
 	VerifiedPerson bool `gorm:"not null;default:false" json:"verified_person"` // Flag indicating whether the user is a verified person
 
 	Email string `gorm:"size:255;unique;not null" json:"-"` // The user's email address, hidden in JSON responses
 
-	MFA       string `json:"-"`                              // Multi-Factor Authentication details, if enabled, not exposed through API
-	Suspended bool   `gorm:"default:false" json:"suspended"` // Flag indicating whether the user's account is suspended
+	MFA         string `json:"-"`                              // Multi-Factor Authentication details, if enabled, not exposed through API
+	MFAPending  string `json:"-"`                              // Secret awaiting confirmation during MFA enrollment, not exposed through API
+	MFALastStep int64  `gorm:"not null;default:0" json:"-"`    // Last accepted TOTP time step, used to reject reused codes
+	Suspended   bool   `gorm:"default:false" json:"suspended"` // Flag indicating whether the user's account is suspended
+
+	SuspensionReason string     `gorm:"type:text" json:"-"` // Reason given to the user for the suspension, not exposed through API
+	SuspendedUntil   *time.Time `json:"-"`                  // Time the suspension ends, never if empty
 
 	// Relationships
 	Following []Follow `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"following,omitempty"`     // List of users that this user is following
@@ -42,9 +47,13 @@ type User struct {
 	Posts []Post `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"` // Posts created by the user
 	Likes []Like `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"` // Likes made by the user on posts
 
+	Roles []UserRole `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"roles,omitempty"` // Roles granted to the user
+
 	// Fields Hidden from GORM
 	YouFollow  bool `gorm:"-" json:"you_follow"`  // Flag indicating whether the current user follows this user
 	FollowsYou bool `gorm:"-" json:"follows_you"` // Flag indicating whether this user follows the current user
+
+	Permissions []string `gorm:"-" json:"permissions,omitempty"` // Permissions granted through the user's roles, only set for the user's own account
 }
 
 func (u *User) AfterFind(tx *gorm.DB) (err error) {
@@ -78,6 +87,29 @@ type Follow struct {
 	UserID string `gorm:"not null" json:"user_id"`                                                                             // ID of the user who is following
 	User   User   `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"user,omitempty"` // The user who is following
 
-	FollowedID string `gorm:"not null" json:"followed_id"`                                                                                 // ID of the user being followed
+	FollowedID string `gorm:"not null;index" json:"followed_id"`                                                                           // ID of the user being followed
 	Followed   User   `gorm:"foreignKey:FollowedID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"followed,omitempty"` // The user being followed
 }
+
+// Mute represents a User hiding another User's posts from their timeline, without the other User knowing.
+type Mute struct {
+	BaseModel
+
+	UserID string `gorm:"not null;uniqueIndex:idx_mute" json:"user_id"`                                                        // ID of the user who muted
+	User   User   `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"user,omitempty"` // The user who muted
+
+	MutedID string `gorm:"not null;uniqueIndex:idx_mute" json:"muted_id"`                                                         // ID of the user being muted
+	Muted   User   `gorm:"foreignKey:MutedID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"muted,omitempty"` // The user being muted
+}
+
+// Block represents a User cutting off another User, neither can follow the other, see the other's posts,
+// or reply to, repost or like them.
+type Block struct {
+	BaseModel
+
+	UserID string `gorm:"not null;uniqueIndex:idx_block" json:"user_id"`                                                       // ID of the user who blocked
+	User   User   `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"user,omitempty"` // The user who blocked
+
+	BlockedID string `gorm:"not null;uniqueIndex:idx_block" json:"blocked_id"`                                                          // ID of the user being blocked
+	Blocked   User   `gorm:"foreignKey:BlockedID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"blocked,omitempty"` // The user being blocked
+}
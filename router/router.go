@@ -2,7 +2,6 @@ package router
 
 import (
 	"fmt"
-	mw "github.com/twibber/api/app/middleware"
 	cfg "github.com/twibber/api/config"
 	"strings"
 	"time"
@@ -84,9 +83,12 @@ func Configure() *fiber.App {
 
 	// Segregate routes
 	routes.Auth(app.Group("/auth"))
-	routes.Account(app.Group("/account", mw.Auth(false)))
+	routes.Account(app.Group("/account"))
+	routes.OAuth(app.Group("/oauth"))
 	routes.Posts(app.Group("/posts"))
+	routes.Timeline(app.Group("/timeline"))
 	routes.Users(app.Group("/users"))
+	routes.Admin(app.Group("/admin"))
 
 	return app
 }
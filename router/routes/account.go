@@ -3,27 +3,60 @@ package routes
 import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/twibber/api/app/controllers/account"
+	mw "github.com/twibber/api/app/middleware"
+	"github.com/twibber/api/lib"
 )
 
 func Account(app fiber.Router) {
-	app.Get("/", account.GetAccount)
-	app.Get("/email", account.GetAccountEmail)
+	// Only reading the account is open to API tokens, everything else requires a session.
+	session := mw.Auth(false)
+	read := mw.Auth(false, lib.ScopeAccountRead)
 
-	app.Get("/connections", account.ListConnections)
+	// Changes to credentials and security settings are blocked for staff impersonating the user.
+	sensitive := mw.NoImpersonation
+
+	app.Get("/", read, account.GetAccount)
+	app.Get("/email", read, account.GetAccountEmail)
+	app.Post("/email", session, sensitive, account.ChangeEmail)
+
+	app.Get("/connections", session, account.ListConnections)
+	app.Post("/connection/:provider", session, sensitive, account.LinkConnection)
+	// Auth is attached to each route rather than with Use on a group, as a group's middleware runs for every request
+	// under its prefix, such as those to /connection/:provider, which would then be authenticated twice.
 	connection := app.Group("/connection/:connection")
 	{
-		connection.Get("/", account.GetConnection)
-		connection.Patch("/password", account.UpdateConnectionPassword)
+		connection.Get("/", session, account.GetConnection)
+		connection.Delete("/", session, sensitive, account.DeleteConnection)
+		connection.Patch("/password", session, sensitive, account.UpdateConnectionPassword)
 	}
 
-	app.Get("/sessions", account.ListSessions)
-	session := app.Group("/session/:session")
+	app.Get("/apps", session, account.ListApps)
+	app.Delete("/app/:client", session, sensitive, account.RevokeApp)
+
+	app.Get("/mutes", session, account.ListMutes)
+	app.Get("/blocks", session, account.ListBlocks)
+
+	app.Get("/sessions", session, account.ListSessions)
+	app.Delete("/sessions", session, sensitive, account.DeleteSessions)
+	sessions := app.Group("/session/:session")
 	{
-		session.Get("/", account.GetSession)
-		session.Delete("/", account.DeleteSession)
+		sessions.Get("/", session, account.GetSession)
+		sessions.Delete("/", session, sensitive, account.DeleteSession)
 	}
 
-	app.Post("/image/:type", account.UpdateProfileImages)
+	app.Get("/tokens", session, account.ListTokens)
+	app.Post("/tokens", mw.Auth(true), sensitive, account.CreateToken)
+	app.Delete("/token/:token", session, sensitive, account.DeleteToken)
+
+	app.Get("/mfa", session, account.GetMFA)
+	app.Post("/mfa", session, sensitive, account.EnrollMFA)
+	app.Post("/mfa/confirm", session, sensitive, account.ConfirmMFA)
+	app.Post("/mfa/regenerate", session, sensitive, account.RegenerateMFA)
+	app.Delete("/mfa", session, sensitive, account.DisableMFA)
+	app.Get("/mfa/recovery", session, sensitive, account.ListRecoveryCodes)
+	app.Post("/mfa/recovery", session, sensitive, account.RegenerateRecoveryCodes)
+
+	app.Post("/image/:type", session, account.UpdateProfileImages)
 
-	app.Patch("/", account.UpdateProfile)
+	app.Patch("/", session, account.UpdateProfile)
 }
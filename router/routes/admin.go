@@ -0,0 +1,31 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/twibber/api/app/controllers/admin"
+	mw "github.com/twibber/api/app/middleware"
+	"github.com/twibber/api/lib"
+)
+
+func Admin(app fiber.Router) {
+	app.Use(mw.Auth(true), mw.NoImpersonation)
+
+	roles := mw.Require(lib.PermRolesManage)
+	app.Get("/roles", roles, admin.ListRoles)
+	app.Get("/audit", mw.Require(lib.PermAuditRead), admin.ListAuditLogs)
+
+	userRouter := app.Group("/user/:user")
+	{
+		userRouter.Get("/roles", roles, admin.ListUserRoles)
+		userRouter.Put("/role/:role", roles, admin.GrantRole)
+		userRouter.Delete("/role/:role", roles, admin.RevokeRole)
+
+		userRouter.Post("/impersonate", mw.Require(lib.PermUsersImpersonate), admin.Impersonate)
+
+		userRouter.Put("/suspension", mw.Require(lib.PermUsersSuspend), admin.SuspendUser)
+		userRouter.Delete("/suspension", mw.Require(lib.PermUsersSuspend), admin.UnsuspendUser)
+
+		userRouter.Put("/verified", mw.Require(lib.PermUsersVerify), admin.VerifyUser)
+		userRouter.Delete("/verified", mw.Require(lib.PermUsersVerify), admin.UnverifyUser)
+	}
+}
@@ -4,14 +4,41 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/twibber/api/app/controllers/auth"
 	"github.com/twibber/api/app/controllers/auth/email"
+	"github.com/twibber/api/app/controllers/auth/mfa"
+	"github.com/twibber/api/app/controllers/auth/oauth"
+	"github.com/twibber/api/app/controllers/auth/webauthn"
 	mw "github.com/twibber/api/app/middleware"
+	"github.com/twibber/api/models"
 )
 
 func Auth(app fiber.Router) {
+	app.Use(mw.RateLimit(authLimit))
+
 	app.Post("/email/register", email.Register)
 	app.Post("/email/login", email.Login)
 	app.Post("/email/verify", mw.Auth(false), email.Verify)
 	app.Post("/email/resend", email.ResendCode)
+	app.Post("/email/forgot", email.ForgotPassword)
+	app.Post("/email/reset", email.ResetPassword)
+	app.Post("/email/magic", email.SendMagicLink)
+	app.Post("/email/magic/consume", email.ConsumeMagicLink)
+	app.Post("/email/change", email.ConfirmEmailChange)
+	app.Post("/email/revert", email.RevertEmailChange)
+
+	app.Post("/mfa/verify", mfa.Verify)
+
+	app.Get("/google", oauth.Redirect(models.ProviderGoogleType))
+	app.Get("/google/callback", oauth.Callback(models.ProviderGoogleType))
+
+	app.Get("/github", oauth.Redirect(models.ProviderGitHubType))
+	app.Get("/github/callback", oauth.Callback(models.ProviderGitHubType))
+
+	app.Post("/webauthn/register/begin", mw.Auth(false), mw.NoImpersonation, webauthn.BeginRegistration)
+	app.Post("/webauthn/register/finish", mw.Auth(false), mw.NoImpersonation, webauthn.FinishRegistration)
+	app.Post("/webauthn/login/begin", webauthn.BeginLogin)
+	app.Post("/webauthn/login/finish", webauthn.FinishLogin)
+
+	app.Post("/session/revoke", auth.RevokeSession)
 
 	app.All("/logout", auth.Logout)
 }
@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"github.com/twibber/api/lib"
+	"time"
+)
+
+// Rate limit policies applied to routes, requests are counted per user when authenticated and per IP otherwise.
+var (
+	authLimit   = lib.RateLimitPolicy{Name: "auth", Limit: 30, Period: time.Minute}
+	postLimit   = lib.RateLimitPolicy{Name: "post", Limit: 30, Period: 15 * time.Minute}
+	likeLimit   = lib.RateLimitPolicy{Name: "like", Limit: 120, Period: 15 * time.Minute}
+	followLimit = lib.RateLimitPolicy{Name: "follow", Limit: 60, Period: time.Hour}
+)
@@ -0,0 +1,25 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/twibber/api/app/controllers/oauthserver"
+	mw "github.com/twibber/api/app/middleware"
+)
+
+func OAuth(app fiber.Router) {
+	session := mw.Auth(true)
+	sensitive := mw.NoImpersonation
+
+	app.Get("/clients", session, oauthserver.ListClients)
+	app.Post("/clients", session, sensitive, oauthserver.CreateClient)
+	app.Delete("/client/:client", session, sensitive, oauthserver.DeleteClient)
+
+	app.Get("/authorize", session, oauthserver.GetAuthorize)
+	app.Post("/authorize", session, sensitive, oauthserver.Authorize)
+
+	// Called by the client applications themselves, which authenticate with their own credentials.
+	limit := mw.RateLimit(authLimit)
+	app.Post("/token", limit, oauthserver.Token)
+	app.Post("/revoke", limit, oauthserver.Revoke)
+	app.Post("/introspect", limit, oauthserver.Introspect)
+}
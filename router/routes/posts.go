@@ -4,21 +4,26 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/twibber/api/app/controllers/posts"
 	mw "github.com/twibber/api/app/middleware"
+	"github.com/twibber/api/lib"
 )
 
 func Posts(app fiber.Router) {
-	app.Post("/", mw.Auth(true), posts.CreatePost)
+	app.Post("/", mw.Auth(true, lib.ScopePostsWrite), mw.RateLimit(postLimit), posts.CreatePost)
 	app.Get("/", posts.ListPosts)
 
 	postRouter := app.Group("/:post")
 	{
 		postRouter.Get("/", posts.GetPost)
-		postRouter.Delete("/", mw.Auth(true), posts.DeletePost)
+		postRouter.Get("/thread", posts.GetThread)
+		postRouter.Delete("/", mw.Auth(true, lib.ScopePostsWrite), posts.DeletePost)
 
-		postRouter.Post("/reply", mw.Auth(true), posts.CreateReply)
-		postRouter.Post("/repost", mw.Auth(true), posts.CreateRepost)
+		postRouter.Post("/reply", mw.Auth(true, lib.ScopePostsWrite), mw.RateLimit(postLimit), posts.CreateReply)
+		postRouter.Post("/repost", mw.Auth(true, lib.ScopePostsWrite), mw.RateLimit(postLimit), posts.CreateRepost)
 
-		postRouter.Post("/like", mw.Auth(true), posts.LikePost)
-		postRouter.Delete("/like", mw.Auth(true), posts.UnlikePost)
+		postRouter.Post("/like", mw.Auth(true, lib.ScopeLikesWrite), mw.RateLimit(likeLimit), posts.LikePost)
+		postRouter.Delete("/like", mw.Auth(true, lib.ScopeLikesWrite), mw.RateLimit(likeLimit), posts.UnlikePost)
+
+		postRouter.Post("/mute", mw.Auth(true), posts.MuteConversation)
+		postRouter.Delete("/mute", mw.Auth(true), posts.UnmuteConversation)
 	}
 }
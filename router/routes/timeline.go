@@ -0,0 +1,12 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/twibber/api/app/controllers/posts"
+	mw "github.com/twibber/api/app/middleware"
+	"github.com/twibber/api/lib"
+)
+
+func Timeline(app fiber.Router) {
+	app.Get("/home", mw.Auth(false, lib.ScopePostsRead), posts.HomeTimeline)
+}
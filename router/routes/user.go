@@ -5,6 +5,7 @@ import (
 	"github.com/twibber/api/app/controllers/posts"
 	"github.com/twibber/api/app/controllers/users"
 	mw "github.com/twibber/api/app/middleware"
+	"github.com/twibber/api/lib"
 )
 
 func Users(app fiber.Router) {
@@ -13,14 +14,19 @@ func Users(app fiber.Router) {
 	userRouter := app.Group("/:user")
 	{
 		userRouter.Get("/", users.GetUserByUsername)
-		userRouter.Delete("/", mw.Auth(true), mw.AdminCheck, users.DeleteUser)
+		userRouter.Delete("/", mw.Auth(true), mw.NoImpersonation, mw.Require(lib.PermUsersDelete), users.DeleteUser)
 
 		userRouter.Get("/posts", posts.GetPostsByUser)
 
 		userRouter.Get("/followers", users.GetFollowersByUsername)
 		userRouter.Get("/following", users.GetFollowingByUsername)
 
-		userRouter.Post("/follow", mw.Auth(true), users.FollowUser)
-		userRouter.Delete("/follow", mw.Auth(true), users.UnfollowUser)
+		userRouter.Post("/follow", mw.Auth(true, lib.ScopeFollowsWrite), mw.RateLimit(followLimit), users.FollowUser)
+		userRouter.Delete("/follow", mw.Auth(true, lib.ScopeFollowsWrite), mw.RateLimit(followLimit), users.UnfollowUser)
+
+		userRouter.Post("/mute", mw.Auth(true), users.MuteUser)
+		userRouter.Delete("/mute", mw.Auth(true), users.UnmuteUser)
+		userRouter.Post("/block", mw.Auth(true), users.BlockUser)
+		userRouter.Delete("/block", mw.Auth(true), users.UnblockUser)
 	}
 }
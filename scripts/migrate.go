@@ -7,6 +7,8 @@ import (
 )
 
 func main() {
+	lib.ConnectDB()
+
 	log.Info("migrating database")
 	lib.MigrateDB()
 }